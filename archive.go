@@ -0,0 +1,291 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// archiveSchemaVersion is stored in the database's user_version, so that
+	// future versions can tell which migrations an archive needs.
+	archiveSchemaVersion = 1
+
+	// archiveBusyTimeout is how long writes wait for a lock, e.g. while the
+	// archive is being read by another program.
+	archiveBusyTimeout = 5 * time.Second
+)
+
+var (
+	ErrArchiveTooNew = errors.New("archive was created by a newer version")
+)
+
+// archiveSchema creates the archive's tables. Times are stored as Unix
+// timestamps (in seconds), which SQLite's date functions understand with the
+// 'unixepoch' modifier.
+//
+//   - posts holds the latest snapshot of every post, as JSON (a FeedPost),
+//     along with a few columns that are useful for queries.
+//   - post_history records the score and comment count of a post whenever
+//     either has changed since the previous crawl.
+//   - crawl_state tracks the progress through each feed, so that a crawl can
+//     resume where it left off after a restart.
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS posts (
+    id            TEXT PRIMARY KEY,
+    subreddit     TEXT NOT NULL,
+    title         TEXT NOT NULL,
+    author        TEXT NOT NULL,
+    created       INTEGER NOT NULL,
+    score         INTEGER NOT NULL,
+    comment_count INTEGER NOT NULL,
+    data          TEXT NOT NULL,
+    first_seen    INTEGER NOT NULL,
+    last_seen     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS posts_subreddit_created ON posts (subreddit, created);
+
+CREATE TABLE IF NOT EXISTS post_history (
+    post_id       TEXT NOT NULL REFERENCES posts (id),
+    seen_at       INTEGER NOT NULL,
+    score         INTEGER NOT NULL,
+    comment_count INTEGER NOT NULL,
+    PRIMARY KEY (post_id, seen_at)
+);
+
+CREATE TABLE IF NOT EXISTS crawl_state (
+    subreddit    TEXT NOT NULL,
+    sort         TEXT NOT NULL,
+    after        TEXT NOT NULL,
+    count        INTEGER NOT NULL,
+    pages        INTEGER NOT NULL,
+    started_at   INTEGER NOT NULL,
+    updated_at   INTEGER NOT NULL,
+    completed_at INTEGER NOT NULL,
+    PRIMARY KEY (subreddit, sort)
+);
+`
+
+// Archive stores crawled posts in a SQLite database. It's safe for concurrent
+// use. The SQLite driver needs cgo, so the archive (and the crawl subcommand)
+// is only built with cgo enabled; see crawl_nocgo.go for the stand-in.
+type Archive struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// CrawlState is the progress of a crawl through one feed. A crawl that's in
+// progress has the cursor of the next page in After (and its Count), while a
+// finished one has an empty After and its CompletedAt set.
+type CrawlState struct {
+	Subreddit string
+	Sort      string
+
+	After string
+	Count int
+	Pages int // fetched so far in the current crawl
+
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+	CompletedAt time.Time
+}
+
+// InProgress reports whether the crawl stopped before it finished.
+func (cs CrawlState) InProgress() bool {
+	return cs.After != ""
+}
+
+// OpenArchive opens (or creates) the archive at 'path', creating its tables if
+// necessary.
+func OpenArchive(path string) (*Archive, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	// SQLite reads the DSN as a URI, so characters like '?', '#' and '%' in
+	// the path have to be escaped
+	dsn := (&url.URL{
+		Scheme: "file",
+		Opaque: (&url.URL{Path: path}).EscapedPath(),
+		RawQuery: fmt.Sprintf("_busy_timeout=%d&_journal_mode=WAL&_foreign_keys=on",
+			archiveBusyTimeout.Milliseconds()),
+	}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{db: db, now: time.Now}
+	if err := archive.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to set up %s: %w", path, err)
+	}
+	return archive, nil
+}
+
+// Close closes the database.
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+func (a *Archive) migrate() error {
+
+	var version int
+	if err := a.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	switch {
+	case version > archiveSchemaVersion:
+		return fmt.Errorf("%w (schema version %d)", ErrArchiveTooNew, version)
+	case version == archiveSchemaVersion:
+		return nil
+	}
+
+	if _, err := a.db.Exec(archiveSchema); err != nil {
+		return err
+	}
+	_, err := a.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", archiveSchemaVersion))
+	return err
+}
+
+// CrawlState returns the progress of the crawl through the feed of
+// 'subreddit' sorted by 'sort'. Feeds that were never crawled have a zero
+// CrawlState.
+func (a *Archive) CrawlState(ctx context.Context, subreddit string, sort string) (CrawlState, error) {
+
+	state := CrawlState{Subreddit: subreddit, Sort: sort}
+	var startedAt, updatedAt, completedAt int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT after, count, pages, started_at, updated_at, completed_at
+		FROM crawl_state
+		WHERE subreddit = ? AND sort = ?`,
+		subreddit, sort,
+	).Scan(&state.After, &state.Count, &state.Pages, &startedAt, &updatedAt, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	state.StartedAt = unixTime(startedAt)
+	state.UpdatedAt = unixTime(updatedAt)
+	state.CompletedAt = unixTime(completedAt)
+	return state, nil
+}
+
+// SavePage stores the posts of one crawled page together with the crawl's
+// progress, in a single transaction, so a crawl never resumes past posts that
+// weren't stored. New posts are inserted, and known ones are updated with the
+// latest snapshot. Either way, the score and comment count are added to the
+// post's history if they've changed.
+func (a *Archive) SavePage(ctx context.Context, posts []FeedPost, state CrawlState) error {
+
+	now := a.now().Unix()
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, post := range posts {
+		if err := upsertPost(ctx, tx, post, now); err != nil {
+			return fmt.Errorf("failed to store %s: %w", post.ID, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO crawl_state
+			(subreddit, sort, after, count, pages, started_at, updated_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (subreddit, sort) DO UPDATE SET
+			after = excluded.after,
+			count = excluded.count,
+			pages = excluded.pages,
+			started_at = excluded.started_at,
+			updated_at = excluded.updated_at,
+			completed_at = excluded.completed_at`,
+		state.Subreddit, state.Sort, state.After, state.Count, state.Pages,
+		unixSeconds(state.StartedAt), now, unixSeconds(state.CompletedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store crawl state: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func upsertPost(ctx context.Context, tx *sql.Tx, post FeedPost, now int64) error {
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+
+	// The previous values decide whether there's any history to record
+	var score, commentCount int
+	err = tx.QueryRowContext(ctx,
+		"SELECT score, comment_count FROM posts WHERE id = ?", post.ID,
+	).Scan(&score, &commentCount)
+	isNew := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !isNew {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO posts
+			(id, subreddit, title, author, created, score, comment_count, data, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			title = excluded.title,
+			score = excluded.score,
+			comment_count = excluded.comment_count,
+			data = excluded.data,
+			last_seen = excluded.last_seen`,
+		post.ID, post.Subreddit, post.Title, post.OP, post.Timestamp.Unix(),
+		post.Score, post.CommentCount, string(data), now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !isNew && score == post.Score && commentCount == post.CommentCount {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO post_history (post_id, seen_at, score, comment_count)
+		VALUES (?, ?, ?, ?)`,
+		post.ID, now, post.Score, post.CommentCount,
+	)
+	return err
+}
+
+// unixSeconds and unixTime convert between times and the columns they're
+// stored in. The zero time is stored as 0.
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,127 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestArchive(t *testing.T) *Archive {
+	t.Helper()
+	archive, err := OpenArchive(filepath.Join(t.TempDir(), archiveFileName))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = archive.Close()
+	})
+	return archive
+}
+
+func TestOpenArchiveEscapesPath(t *testing.T) {
+	for _, name := range []string{"a?b.db", "a#b.db", "a%3Fb.db", "a b.db"} {
+		path := filepath.Join(t.TempDir(), name)
+		archive, err := OpenArchive(path)
+		if err != nil {
+			t.Fatalf("OpenArchive(%q) failed: %v", path, err)
+		}
+		_ = archive.Close()
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("OpenArchive(%q) didn't create the file: %v", path, err)
+		}
+	}
+}
+
+func TestArchiveSavePageRecordsHistory(t *testing.T) {
+	archive := openTestArchive(t)
+	ctx := context.Background()
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	state := CrawlState{Subreddit: "foo", Sort: "new"}
+
+	steps := []struct {
+		name         string
+		score        int
+		commentCount int
+		history      int // rows after the step
+	}{
+		{"new post", 10, 1, 1},
+		{"unchanged", 10, 1, 1},
+		{"score changed", 12, 1, 2},
+		{"comments changed", 12, 3, 3},
+		{"unchanged again", 12, 3, 3},
+	}
+
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * time.Hour)
+		archive.now = func() time.Time { return now }
+
+		post := FeedPost{ID: "t3_abc", Subreddit: "foo", Title: step.name, Score: step.score, CommentCount: step.commentCount}
+		if err := archive.SavePage(ctx, []FeedPost{post}, state); err != nil {
+			t.Fatalf("%s: SavePage failed: %v", step.name, err)
+		}
+
+		var history int
+		if err := archive.db.QueryRow("SELECT COUNT(*) FROM post_history WHERE post_id = ?", post.ID).Scan(&history); err != nil {
+			t.Fatalf("%s: failed to count history: %v", step.name, err)
+		}
+		if history != step.history {
+			t.Errorf("%s: %d history rows, want %d", step.name, history, step.history)
+		}
+
+		var title string
+		var score, firstSeen, lastSeen int64
+		err := archive.db.QueryRow("SELECT title, score, first_seen, last_seen FROM posts WHERE id = ?", post.ID).
+			Scan(&title, &score, &firstSeen, &lastSeen)
+		if err != nil {
+			t.Fatalf("%s: failed to read post: %v", step.name, err)
+		}
+		if title != step.name || score != int64(step.score) {
+			t.Errorf("%s: stored %q with score %d, want the latest snapshot", step.name, title, score)
+		}
+		if firstSeen != start.Unix() {
+			t.Errorf("%s: first_seen = %d, want %d", step.name, firstSeen, start.Unix())
+		}
+		if lastSeen != now.Unix() {
+			t.Errorf("%s: last_seen = %d, want %d", step.name, lastSeen, now.Unix())
+		}
+	}
+}
+
+func TestArchiveCrawlState(t *testing.T) {
+	archive := openTestArchive(t)
+	ctx := context.Background()
+
+	state, err := archive.CrawlState(ctx, "foo", "new")
+	if err != nil {
+		t.Fatalf("CrawlState failed: %v", err)
+	}
+	if state.InProgress() || !state.StartedAt.IsZero() || !state.CompletedAt.IsZero() {
+		t.Errorf("CrawlState of an unknown feed = %+v, want a zero state", state)
+	}
+
+	started := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	saved := CrawlState{Subreddit: "foo", Sort: "new", After: "t3_abc", Count: 25, Pages: 1, StartedAt: started}
+	if err := archive.SavePage(ctx, nil, saved); err != nil {
+		t.Fatalf("SavePage failed: %v", err)
+	}
+
+	state, err = archive.CrawlState(ctx, "foo", "new")
+	if err != nil {
+		t.Fatalf("CrawlState failed: %v", err)
+	}
+	if state.After != saved.After || state.Count != saved.Count || state.Pages != saved.Pages {
+		t.Errorf("CrawlState = %+v, want the cursor of %+v", state, saved)
+	}
+	if !state.StartedAt.Equal(started) || !state.CompletedAt.IsZero() {
+		t.Errorf("CrawlState started %s and completed %s, want %s and never", state.StartedAt, state.CompletedAt, started)
+	}
+
+	// Other sort orders are separate feeds
+	if state, err := archive.CrawlState(ctx, "foo", "top"); err != nil || state.InProgress() {
+		t.Errorf("CrawlState(foo, top) = %+v, %v, want a zero state", state, err)
+	}
+}
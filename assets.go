@@ -0,0 +1,85 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// NOTE: Our assets are embedded in the binary to ensure that they are always
+// available, regardless of which directory the application is running in. This
+// also helps to simplify testing, since the embedded files are accessible at
+// both test time and run time.
+//
+//go:embed templates static locales
+var embeddedAssets embed.FS
+
+// overlayFS layers several file systems on top of each other. Files in earlier
+// layers take precedence over files with the same path in later layers, and
+// directory listings from ReadDir are merged across all layers.
+//
+// This lets an overlay directory customize individual templates or static
+// files (or add new ones, such as themes) while everything else still comes
+// from the embedded assets.
+type overlayFS struct {
+	layers []fs.FS
+}
+
+func newOverlayFS(layers ...fs.FS) *overlayFS {
+	return &overlayFS{layers: layers}
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for _, layer := range o.layers {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS, so that fs.Glob and fs.WalkDir see the
+// merged directory rather than just the first layer's copy of it.
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var merged []fs.DirEntry
+	seen := map[string]bool{}
+	found := false
+	for _, layer := range o.layers {
+		entries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, entry := range entries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				merged = append(merged, entry)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	slices.SortFunc(merged, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return merged, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strings"
+)
+
+// ------------------------------------------------------------------------- //
+// Consent Policy
+// ------------------------------------------------------------------------- //
+
+// ConsentPolicy determines what happens when Reddit asks us to opt in to
+// viewing an NSFW or quarantined subreddit.
+type ConsentPolicy int
+
+const (
+	// ConsentPolicyPrompt shows a local consent page. The opt-in is only
+	// submitted to Reddit once the user confirms.
+	ConsentPolicyPrompt ConsentPolicy = iota
+
+	// ConsentPolicyAccept submits the opt-in automatically.
+	ConsentPolicyAccept
+
+	// ConsentPolicyDeny treats the interstitial as an error.
+	ConsentPolicyDeny
+)
+
+func (cp ConsentPolicy) String() string {
+	switch cp {
+	case ConsentPolicyPrompt:
+		return "prompt"
+	case ConsentPolicyAccept:
+		return "accept"
+	case ConsentPolicyDeny:
+		return "deny"
+	default:
+		return fmt.Sprintf("ConsentPolicy(%d)", cp)
+	}
+}
+
+func ConsentPolicyFromString(s string) (ConsentPolicy, error) {
+	switch s {
+	case "prompt":
+		return ConsentPolicyPrompt, nil
+	case "accept":
+		return ConsentPolicyAccept, nil
+	case "deny":
+		return ConsentPolicyDeny, nil
+	default:
+		return ConsentPolicy(-1), fmt.Errorf("'%s' is not a consent policy", s)
+	}
+}
+
+// ------------------------------------------------------------------------- //
+// Consent Kind
+// ------------------------------------------------------------------------- //
+
+type ConsentKind int
+
+const (
+	ConsentKindOver18 ConsentKind = iota
+	ConsentKindQuarantine
+)
+
+func (ck ConsentKind) String() string {
+	switch ck {
+	case ConsentKindOver18:
+		return "over18"
+	case ConsentKindQuarantine:
+		return "quarantine"
+	default:
+		return fmt.Sprintf("ConsentKind(%d)", ck)
+	}
+}
+
+func ConsentKindFromString(s string) (ConsentKind, error) {
+	switch s {
+	case "over18":
+		return ConsentKindOver18, nil
+	case "quarantine":
+		return ConsentKindQuarantine, nil
+	default:
+		return ConsentKind(-1), fmt.Errorf("'%s' is not a consent kind", s)
+	}
+}
+
+func (ck ConsentKind) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%s"`, ck.String())), nil
+}
+
+func (ck *ConsentKind) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("ConsentKind should be a string, got %s", data)
+	}
+
+	k, err := ConsentKindFromString(s)
+	if err != nil {
+		return err
+	}
+
+	*ck = k
+	return nil
+}
+
+// ------------------------------------------------------------------------- //
+// Consent Errors
+// ------------------------------------------------------------------------- //
+
+// ConsentError is returned when Reddit serves an opt-in interstitial instead
+// of the requested feed. It unwraps to ErrOver18Required or
+// ErrSubredditQuarantined, depending on Kind.
+type ConsentError struct {
+	Kind ConsentKind
+}
+
+func (c *ConsentError) Error() string {
+	return c.Unwrap().Error()
+}
+
+func (c *ConsentError) Unwrap() error {
+	if c.Kind == ConsentKindQuarantine {
+		return ErrSubredditQuarantined
+	}
+	return ErrOver18Required
+}
+
+// interstitialSubreddit finds the subreddit an interstitial's opt-in form is
+// for, which isn't otherwise known when the page wasn't a subreddit's feed
+// (e.g. a post's comments page). The quarantine form names it, and the over18
+// form's destination is the page that was requested:
+//
+//	<input type="hidden" name="sr_name" value="[SUBREDDIT]">
+//	<form method="post" action="/over18?dest=https://old.reddit.com/r/[SUBREDDIT]/...">
+func interstitialSubreddit(doc *html.Node) (string, bool) {
+
+	if input, err := BreadthFirstSearch(doc,
+		And(
+			IsTag(atom.Input),
+			HasAttributeWithValue("name", "sr_name"),
+		),
+		Not(IsTag(atom.Head)),
+	); err == nil {
+		if name, ok := GetAttribute(input, "value"); ok && name != "" {
+			return name, true
+		}
+	}
+
+	form, err := BreadthFirstSearch(doc,
+		And(
+			IsTag(atom.Form),
+			HasAttributeWithValueRegex("action", "over18"),
+		),
+		Not(IsTag(atom.Head)),
+	)
+	if err != nil {
+		return "", false
+	}
+	action, _ := GetAttribute(form, "action")
+	u, err := url.Parse(action)
+	if err != nil {
+		return "", false
+	}
+	dest, err := url.Parse(u.Query().Get("dest"))
+	if err != nil {
+		return "", false
+	}
+	segments := splitPath(dest.Path)
+	if len(segments) < 2 || !strings.EqualFold(segments[0], "r") {
+		return "", false
+	}
+	return segments[1], true
+}
+
+// ------------------------------------------------------------------------- //
+// Consent Submission
+// ------------------------------------------------------------------------- //
+
+// Consent submits Reddit's opt-in form for the subreddit described by
+// 'options'. Reddit responds by setting a cookie, which is retained by the
+// client's cookie jar so that subsequent requests are allowed through.
+func (rp *RedditParser) Consent(
+	ctx context.Context,
+	kind ConsentKind,
+	options ...FeedOption,
+) error {
+
+	opts := &feedOpts{
+		BaseURL: defaultBaseURL,
+	}
+	for _, opt := range options {
+		err := opt(opts)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Subreddit == nil {
+		return fmt.Errorf("consent requires a subreddit")
+	}
+
+	// These mirror the forms in old Reddit's interstitial pages:
+	//
+	//	<form method="post" action="/over18?dest=...">
+	//	  <button type="submit" name="over18" value="yes">continue</button>
+	//	</form>
+	//
+	//	<form method="post" action="/quarantine">
+	//	  <input type="hidden" name="sr_name" value="...">
+	//	  <button type="submit" name="accept" value="yes">continue</button>
+	//	</form>
+	var postURL string
+	form := url.Values{}
+	switch kind {
+	case ConsentKindOver18:
+		destOpts := *opts
+		destOpts.BaseURL = ""
+		dest := constructURL(&destOpts)
+		postURL = fmt.Sprintf("%s/over18?%s", opts.BaseURL, url.Values{"dest": {dest}}.Encode())
+		form.Set("over18", "yes")
+	case ConsentKindQuarantine:
+		postURL = fmt.Sprintf("%s/quarantine", opts.BaseURL)
+		form.Set("sr_name", *opts.Subreddit)
+		form.Set("accept", "yes")
+	default:
+		return fmt.Errorf("unsupported consent kind: %v", kind)
+	}
+
+	logF(LevelTrace, "Submitting %s consent: POST %s", kind, postURL)
+	_, _, err := postForm(ctx, rp.Client, postURL, opts.Headers, form)
+	return err
+}
@@ -0,0 +1,419 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	archiveFileName = "archive.db"
+
+	defaultCrawlDepth    = 4
+	defaultCrawlInterval = time.Hour
+	defaultCrawlDelay    = 2 * time.Second
+)
+
+// ------------------------------------------------------------------------- //
+// Crawler
+// ------------------------------------------------------------------------- //
+
+// CrawlTarget is a feed that's crawled: a subreddit (or multireddit, e.g.
+// "a+b") in one sort order.
+type CrawlTarget struct {
+	Subreddit  string
+	SortMethod SortMethod
+}
+
+func (ct CrawlTarget) String() string {
+	return fmt.Sprintf("r/%s/%s", ct.Subreddit, ct.SortMethod.URLString())
+}
+
+// Crawler periodically copies the first pages of its targets into an Archive.
+// Its progress is kept in the archive after every page, so an interrupted
+// crawl picks up at the page it was on when the crawler is restarted. Targets
+// are crawled one at a time, so a Crawler mustn't be run concurrently.
+type Crawler struct {
+	Parser  *RedditParser
+	Archive *Archive
+	Targets []CrawlTarget
+
+	// Depth is the number of pages crawled per target, following each page's
+	// "next" link.
+	Depth int
+
+	// Interval is how long to wait after a target has been crawled before
+	// crawling it again.
+	Interval time.Duration
+
+	// Delay is the pause between two requests, to go easy on Reddit.
+	Delay time.Duration
+
+	// RequestTimeout bounds how long fetching a single page may take. Zero
+	// means no deadline.
+	RequestTimeout time.Duration
+
+	// Headers are sent with every request (e.g. a User-Agent).
+	Headers http.Header
+
+	lastRequest time.Time
+
+	// retryAt holds the targets that failed, and when to try them again.
+	// Their crawls are left in progress, so they'd otherwise be resumed as
+	// soon as another target is due.
+	retryAt map[CrawlTarget]time.Time
+}
+
+// Run crawls the targets whenever they're due, until 'ctx' is cancelled.
+func (c *Crawler) Run(ctx context.Context) error {
+	for {
+		next, err := c.RunOnce(ctx)
+		if err != nil {
+			return err
+		}
+
+		wait := time.Until(next)
+		logF(LevelInfo, "Next crawl in %s", wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			return nil
+		}
+	}
+}
+
+// RunOnce crawls the targets that are due, and returns when the next one will
+// be. Errors fetching a target are logged and it's retried once the Interval
+// has passed. Only errors storing the results (or a cancelled 'ctx') are
+// returned, since those would affect every target.
+func (c *Crawler) RunOnce(ctx context.Context) (time.Time, error) {
+
+	if c.retryAt == nil {
+		c.retryAt = map[CrawlTarget]time.Time{}
+	}
+
+	var next time.Time
+	for _, target := range c.Targets {
+		due, err := c.crawl(ctx, target)
+		if err != nil {
+			if ctx.Err() != nil {
+				return next, ctx.Err()
+			}
+			var storeErr *archiveError
+			if errors.As(err, &storeErr) {
+				return next, err
+			}
+			logF(LevelWarning, "Failed to crawl %s: %v", target, err)
+			due = time.Now().Add(c.Interval)
+			c.retryAt[target] = due
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next, nil
+}
+
+// crawl fetches the target's pages, unless it's not due yet, and returns when
+// it's due next.
+func (c *Crawler) crawl(ctx context.Context, target CrawlTarget) (time.Time, error) {
+
+	if retry, ok := c.retryAt[target]; ok {
+		if time.Now().Before(retry) {
+			return retry, nil
+		}
+		delete(c.retryAt, target)
+	}
+
+	sort := target.SortMethod.URLString()
+	state, err := c.Archive.CrawlState(ctx, target.Subreddit, sort)
+	if err != nil {
+		return time.Time{}, &archiveError{err}
+	}
+
+	switch {
+	case state.InProgress():
+		logF(LevelInfo, "Resuming %s at page %d", target, state.Pages+1)
+	case !state.CompletedAt.IsZero() && time.Since(state.CompletedAt) < c.Interval:
+		return state.CompletedAt.Add(c.Interval), nil
+	default:
+		logF(LevelInfo, "Crawling %s", target)
+		state = CrawlState{
+			Subreddit: target.Subreddit,
+			Sort:      sort,
+			StartedAt: time.Now(),
+		}
+	}
+
+	for state.Pages < c.Depth {
+		feed, err := c.fetch(ctx, target, state)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		posts := make([]FeedPost, 0, len(feed.Posts))
+		for _, post := range feed.Posts {
+			if !post.IsPromoted {
+				posts = append(posts, post)
+			}
+		}
+
+		state.Pages++
+		if feed.NextCursor != nil && feed.NextCursor.After != "" {
+			state.After = feed.NextCursor.After
+			state.Count = feed.NextCursor.Count
+		} else {
+			state.After = ""
+			state.Count = 0
+		}
+		if err := c.finishPage(ctx, posts, &state); err != nil {
+			return time.Time{}, err
+		}
+		logF(LevelDebug, "Archived %d posts from page %d of %s", len(posts), state.Pages, target)
+
+		if !state.InProgress() {
+			break
+		}
+	}
+
+	// The depth may have been lowered since an interrupted crawl
+	if state.InProgress() {
+		if err := c.finishPage(ctx, nil, &state); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	logF(LevelInfo, "Finished crawling %s (%d pages)", target, state.Pages)
+	return state.CompletedAt.Add(c.Interval), nil
+}
+
+// finishPage stores a page of posts along with the crawl's progress. Once the
+// last page has been fetched, the crawl is marked as completed.
+func (c *Crawler) finishPage(ctx context.Context, posts []FeedPost, state *CrawlState) error {
+	if state.Pages >= c.Depth {
+		state.After = ""
+		state.Count = 0
+	}
+	if !state.InProgress() {
+		state.CompletedAt = time.Now()
+	}
+	if err := c.Archive.SavePage(ctx, posts, *state); err != nil {
+		return &archiveError{err}
+	}
+	return nil
+}
+
+// fetch requests the target's next page, once the Delay since the previous
+// request has passed.
+func (c *Crawler) fetch(ctx context.Context, target CrawlTarget, state CrawlState) (*Feed, error) {
+
+	if err := sleep(ctx, time.Until(c.lastRequest.Add(c.Delay))); err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.lastRequest = time.Now()
+	}()
+
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+
+	options := []FeedOption{
+		WithSubreddit(target.Subreddit),
+		WithSortMethod(target.SortMethod),
+		WithHeaders(c.Headers),
+	}
+	if state.InProgress() {
+		options = append(options, WithLastPostID(state.After), WithCount(state.Count))
+	}
+
+	feed, err := c.Parser.Feed(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	if feed.Consent != nil {
+		// Only returned with ConsentPolicyPrompt, which has nobody to ask here
+		return nil, ErrOver18Required
+	}
+	return feed, nil
+}
+
+// archiveError marks errors from the Archive, as opposed to Reddit.
+type archiveError struct {
+	err error
+}
+
+func (ae *archiveError) Error() string {
+	return fmt.Sprintf("archive: %v", ae.err)
+}
+
+func (ae *archiveError) Unwrap() error {
+	return ae.err
+}
+
+// sleep waits for 'd' to pass, or returns early with the context's error if
+// 'ctx' is cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ------------------------------------------------------------------------- //
+// Command
+// ------------------------------------------------------------------------- //
+
+// runCrawl implements the "crawl" subcommand, which archives subreddits into
+// a SQLite database instead of serving them.
+func runCrawl(args []string) {
+
+	flags := flag.NewFlagSet("crawl", flag.ExitOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: %s crawl -subreddits a,b [flags]\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	dbPath := flags.String("db", filepath.Join(defaultDataDir(), archiveFileName),
+		"SQLite database the posts are archived in")
+	subreddits := flags.String("subreddits", "",
+		"comma separated subreddits to crawl (e.g. \"golang,rust\" or \"golang+rust\")")
+	sorts := flags.String("sorts", SortMethodNew.URLString(),
+		"comma separated sort methods to crawl each subreddit in (e.g. \"new,top\")")
+	depth := flags.Int("depth", defaultCrawlDepth,
+		"number of pages to crawl per subreddit and sort method")
+	interval := flags.Duration("interval", defaultCrawlInterval,
+		"how long to wait before crawling a subreddit again")
+	delay := flags.Duration("delay", defaultCrawlDelay,
+		"pause between requests to Reddit")
+	once := flags.Bool("once", false,
+		"crawl whatever is due, then exit")
+	requestTimeout := flags.Duration("request-timeout", defaultRequestTimeout,
+		"deadline for fetching and parsing a single page from Reddit (0 disables)")
+	consentPolicy := flags.String("consent-policy", ConsentPolicyDeny.String(),
+		"how to handle NSFW/quarantine interstitials: accept or deny")
+	fetchSelfText := flags.Bool("fetch-selftext", false,
+		"download comments pages for text posts whose body isn't in the feed page")
+	userAgent := flags.String("user-agent", "",
+		"User-Agent header to send to Reddit (the default client's if empty)")
+	_ = flags.Parse(args)
+
+	crawler := &Crawler{
+		Depth:          *depth,
+		Interval:       *interval,
+		Delay:          *delay,
+		RequestTimeout: *requestTimeout,
+	}
+
+	for _, name := range splitList(*subreddits) {
+		for _, sort := range splitList(*sorts) {
+			target, err := parseCrawlTarget(name, sort)
+			if err != nil {
+				failF("invalid crawl target: %v", err)
+			}
+			crawler.Targets = append(crawler.Targets, target)
+		}
+	}
+	if len(crawler.Targets) == 0 {
+		failF("-subreddits and -sorts must name at least one subreddit and sort method")
+	}
+	if crawler.Depth < 1 {
+		failF("-depth must be at least 1")
+	}
+	if crawler.Interval <= 0 {
+		failF("-interval must be positive")
+	}
+
+	policy, err := ConsentPolicyFromString(*consentPolicy)
+	if err != nil {
+		failF("invalid -consent-policy: %v", err)
+	}
+	if policy == ConsentPolicyPrompt {
+		failF("invalid -consent-policy: there's nobody to prompt while crawling, use accept or deny")
+	}
+	if *userAgent != "" {
+		crawler.Headers = http.Header{"User-Agent": {*userAgent}}
+	}
+
+	client, err := getDefaultHTTPClient()
+	if err != nil {
+		failF("failed to get default http client: %v", err)
+	}
+	crawler.Parser = &RedditParser{
+		Client:               client,
+		ConsentPolicy:        policy,
+		FetchMissingSelfText: *fetchSelfText,
+	}
+
+	crawler.Archive, err = OpenArchive(*dbPath)
+	if err != nil {
+		failF("failed to open archive: %v", err)
+	}
+	defer func() {
+		_ = crawler.Archive.Close()
+	}()
+
+	// SIGINT/SIGTERM stop the crawl. Progress is saved after every page, so
+	// nothing is lost.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logF(LevelInfo, "Archiving %d feeds into %s", len(crawler.Targets), *dbPath)
+	if *once {
+		_, err = crawler.RunOnce(ctx)
+	} else {
+		err = crawler.Run(ctx)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		_ = crawler.Archive.Close()
+		failF("crawl failed: %v", err)
+	}
+}
+
+// parseCrawlTarget validates a subreddit (or "a+b" multireddit) and a sort
+// method given on the command line.
+func parseCrawlTarget(subreddit string, sort string) (CrawlTarget, error) {
+
+	sortMethod, err := SortMethodFromString(sort)
+	if err != nil {
+		return CrawlTarget{}, err
+	}
+
+	names, err := normalizeMultiredditName(subreddit)
+	if err != nil {
+		return CrawlTarget{}, err
+	}
+	return CrawlTarget{Subreddit: names, SortMethod: sortMethod}, nil
+}
+
+// normalizeMultiredditName normalizes each subreddit of a multireddit (e.g.
+// "Golang+rust"), or of a single subreddit.
+func normalizeMultiredditName(name string) (string, error) {
+	names := strings.Split(strings.TrimPrefix(strings.TrimSpace(name), "r/"), "+")
+	for i, name := range names {
+		normalized, err := normalizeSubredditName(name)
+		if err != nil {
+			return "", err
+		}
+		names[i] = normalized
+	}
+	return strings.Join(names, "+"), nil
+}
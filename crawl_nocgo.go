@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package main
+
+// runCrawl stands in for the "crawl" subcommand when cgo is disabled, since
+// the SQLite driver the archive uses is written in C (see archive.go). The
+// server itself doesn't need cgo.
+func runCrawl(_ []string) {
+	failF("the crawl subcommand needs cgo: build with CGO_ENABLED=1 and a C compiler")
+}
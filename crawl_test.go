@@ -0,0 +1,179 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFeedPage returns the markup of a feed page with one post, 'id', linking
+// to the next page unless 'last' is set.
+func fakeFeedPage(subreddit string, id string, last bool) string {
+	var next string
+	if !last {
+		next = fmt.Sprintf(`<div class="nav-buttons"><span class="next-button">`+
+			`<a href="https://old.reddit.com/r/%s/new/?count=25&after=%s">next</a></span></div>`, subreddit, id)
+	}
+	return fmt.Sprintf(`<html><body><div id="siteTable">`+
+		`<div class="thing" data-fullname="%s" data-subreddit="%s" data-score="1" data-comments-count="0">`+
+		`<p class="title"><a class="title">%s</a></p></div></div>%s</body></html>`, id, subreddit, id, next)
+}
+
+// fakeReddit serves a feed of three pages with one post each: t3_p1 on the
+// first page, t3_p2 on the one after it and t3_p3 on the last. Pages listed
+// in 'failing' (by their "after" cursor, "" for the first page) fail instead.
+// Every request's cursor is added to 'requests'.
+func fakeReddit(requests *[]string, failing ...string) *RedditParser {
+	pages := map[string]string{"": "t3_p1", "t3_p1": "t3_p2", "t3_p2": "t3_p3"}
+
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		after := r.URL.Query().Get("after")
+		*requests = append(*requests, after)
+
+		response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: r}
+		id, ok := pages[after]
+		for _, f := range failing {
+			ok = ok && f != after
+		}
+		if !ok {
+			response.StatusCode = http.StatusInternalServerError
+			response.Body = io.NopCloser(strings.NewReader(""))
+			return response, nil
+		}
+		subreddit := strings.Split(strings.TrimPrefix(r.URL.Path, "/r/"), "/")[0]
+		response.Body = io.NopCloser(strings.NewReader(fakeFeedPage(subreddit, id, id == "t3_p3")))
+		return response, nil
+	})
+	return &RedditParser{Client: &http.Client{Transport: transport}}
+}
+
+func TestCrawlerResumesInterruptedCrawl(t *testing.T) {
+	archive := openTestArchive(t)
+	ctx := context.Background()
+	target := CrawlTarget{Subreddit: "foo", SortMethod: SortMethodNew}
+
+	// The second page fails, leaving the crawl in progress
+	var requests []string
+	crawler := &Crawler{
+		Parser:   fakeReddit(&requests, "t3_p1"),
+		Archive:  archive,
+		Targets:  []CrawlTarget{target},
+		Depth:    3,
+		Interval: time.Hour,
+	}
+	if _, err := crawler.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	state, err := archive.CrawlState(ctx, "foo", "new")
+	if err != nil {
+		t.Fatalf("CrawlState failed: %v", err)
+	}
+	if !state.InProgress() || state.After != "t3_p1" || state.Pages != 1 {
+		t.Fatalf("after a failed page, CrawlState = %+v, want page 1 in progress", state)
+	}
+
+	// A new crawler (e.g. after a restart) picks up at the failed page
+	requests = nil
+	crawler = &Crawler{
+		Parser:   fakeReddit(&requests),
+		Archive:  archive,
+		Targets:  []CrawlTarget{target},
+		Depth:    3,
+		Interval: time.Hour,
+	}
+	if _, err := crawler.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if want := []string{"t3_p1", "t3_p2"}; fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("resumed crawl requested pages after %q, want %q", requests, want)
+	}
+	state, err = archive.CrawlState(ctx, "foo", "new")
+	if err != nil {
+		t.Fatalf("CrawlState failed: %v", err)
+	}
+	if state.InProgress() || state.Pages != 3 || state.CompletedAt.IsZero() {
+		t.Errorf("after resuming, CrawlState = %+v, want 3 pages completed", state)
+	}
+
+	var posts int
+	if err := archive.db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+		t.Fatalf("failed to count posts: %v", err)
+	}
+	if posts != 3 {
+		t.Errorf("archived %d posts, want 3", posts)
+	}
+
+	// Completed crawls aren't repeated before the interval has passed
+	requests = nil
+	if _, err := crawler.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("crawled again before the interval passed, requesting %q", requests)
+	}
+}
+
+func TestCrawlerWaitsBeforeRetrying(t *testing.T) {
+	archive := openTestArchive(t)
+	ctx := context.Background()
+	failing := CrawlTarget{Subreddit: "foo", SortMethod: SortMethodNew}
+	healthy := CrawlTarget{Subreddit: "bar", SortMethod: SortMethodNew}
+
+	// Only the first target's second page fails
+	var requests []string
+	parser := fakeReddit(&requests)
+	transport := parser.Client.Transport
+	parser.Client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if strings.HasPrefix(r.URL.Path, "/r/foo/") && r.URL.Query().Get("after") != "" {
+			requests = append(requests, "failed")
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("")),
+				Request:    r,
+			}, nil
+		}
+		return transport.RoundTrip(r)
+	})
+	crawler := &Crawler{
+		Parser:   parser,
+		Archive:  archive,
+		Targets:  []CrawlTarget{failing, healthy},
+		Depth:    3,
+		Interval: time.Hour,
+	}
+
+	before := time.Now()
+	next, err := crawler.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if next.Before(before.Add(crawler.Interval)) {
+		t.Errorf("next crawl at %s, want it an interval after the failure", next)
+	}
+
+	// The failed crawl is in progress, but isn't resumed until it's retried
+	requests = nil
+	if _, err := crawler.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("second run requested %q, want no requests before the retry", requests)
+	}
+
+	// Once the retry is due, the crawl resumes where it failed
+	crawler.retryAt[failing] = time.Now().Add(-time.Second)
+	requests = nil
+	if _, err := crawler.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if want := []string{"failed"}; fmt.Sprint(requests) != fmt.Sprint(want) {
+		t.Errorf("retry requested %q, want only the failed page", requests)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// Dev mode serves templates, messages and static files straight from disk
+// rather than from the copies embedded in the binary, so they can be edited
+// without rebuilding. Templates and messages are reloaded whenever a file
+// changes.
+
+const (
+	defaultWatchInterval = 500 * time.Millisecond
+)
+
+// fileStamp is what we compare to decide whether a file has changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchFiles polls the files below 'root' in 'fsys' every 'interval', calling
+// 'onChange' whenever a file is added, removed or modified. It blocks until
+// 'ctx' is done.
+//
+// NOTE: Polling is less efficient than OS file notifications, but it works the
+// same everywhere (including network file systems and containers with bind
+// mounts) and doesn't need any extra dependencies. The trees we watch are
+// small, so the cost is negligible.
+func watchFiles(
+	ctx context.Context,
+	fsys fs.FS,
+	root string,
+	interval time.Duration,
+	onChange func(),
+) {
+	previous, err := snapshotFiles(fsys, root)
+	if err != nil {
+		logF(LevelWarning, "Failed to scan %s: %v", root, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := snapshotFiles(fsys, root)
+		if err != nil {
+			logF(LevelWarning, "Failed to scan %s: %v", root, err)
+			continue
+		}
+		if !sameFiles(previous, current) {
+			previous = current
+			onChange()
+		}
+	}
+}
+
+// snapshotFiles records the modification time and size of every regular file
+// below 'root'.
+func snapshotFiles(fsys fs.FS, root string) (map[string]fileStamp, error) {
+	files := map[string]fileStamp{}
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return files, err
+}
+
+func sameFiles(a map[string]fileStamp, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stampA := range a {
+		stampB, ok := b[path]
+		if !ok || !stampA.modTime.Equal(stampB.modTime) || stampA.size != stampB.size {
+			return false
+		}
+	}
+	return true
+}
+
+// reloadTemplates re-parses 'registry', logging (rather than failing on) any
+// errors so a typo doesn't take the server down mid-edit.
+func reloadTemplates(registry *TemplateRegistry) {
+	if err := registry.Reload(); err != nil {
+		logF(LevelError, "Failed to reload templates: %v", err)
+		return
+	}
+	logF(LevelInfo, "Reloaded templates")
+}
+
+// reloadMessages re-reads the message catalog. The templates are re-parsed as
+// well, since each locale has its own copy of them.
+func reloadMessages(messages *Catalog, registry *TemplateRegistry) {
+	if err := messages.Reload(); err != nil {
+		logF(LevelError, "Failed to reload messages: %v", err)
+		return
+	}
+	logF(LevelInfo, "Reloaded messages")
+	reloadTemplates(registry)
+}
+
+// noCacheHandler stops browsers from caching responses, so edited static files
+// show up on the next refresh.
+func noCacheHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		h.ServeHTTP(w, r)
+	})
+}
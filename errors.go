@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrSubredditNotFound    = errors.New("subreddit not found")
+	ErrSubredditPrivate     = errors.New("subreddit is private")
+	ErrSubredditBanned      = errors.New("subreddit is banned")
+	ErrSubredditQuarantined = errors.New("subreddit is quarantined")
+	ErrOver18Required       = errors.New("subreddit requires over 18 consent")
+	ErrBadRequest           = errors.New("malformed request")
+	ErrCrossOrigin          = errors.New("request came from another site")
+)
+
+// Error codes reported in the JSON error envelope. These are part of the
+// public JSON schema, so they should not be renamed.
+const (
+	ErrorCodeInternal             = "internal"
+	ErrorCodeTimeout              = "timeout"
+	ErrorCodeNotFound             = "not_found"
+	ErrorCodeSubredditPrivate     = "subreddit_private"
+	ErrorCodeSubredditBanned      = "subreddit_banned"
+	ErrorCodeSubredditQuarantined = "subreddit_quarantined"
+	ErrorCodeOver18Required       = "over18_required"
+	ErrorCodeForbidden            = "forbidden"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeParseFailed          = "parse_failed"
+	ErrorCodeUpstream             = "upstream_error"
+	ErrorCodeBadRequest           = "bad_request"
+	ErrorCodeMethodNotAllowed     = "method_not_allowed"
+)
+
+// ------------------------------------------------------------------------- //
+// Upstream error detection
+// ------------------------------------------------------------------------- //
+
+// classifyHTTPError inspects the body of a failed upstream request for the
+// interstitial old.reddit uses to explain why a subreddit can't be shown (see
+// detectInterstitial). When one is found, the returned error wraps both the
+// matching sentinel error and the original HTTPError. A 404 for a subreddit
+// ('getURL' is "/r/[name]/...") means the subreddit doesn't exist. Otherwise,
+// the HTTPError is returned unchanged.
+func classifyHTTPError(httpErr *HTTPError, getURL string) error {
+	if doc, err := html.Parse(strings.NewReader(httpErr.Body)); err == nil {
+		if reason := detectInterstitial(doc); reason != nil {
+			return fmt.Errorf("%w: %w", reason, httpErr)
+		}
+	}
+	if httpErr.StatusCode == http.StatusNotFound && isSubredditURL(getURL) {
+		return fmt.Errorf("%w: %w", ErrSubredditNotFound, httpErr)
+	}
+	return httpErr
+}
+
+// isSubredditURL reports whether 'getURL' points into a subreddit, e.g.
+// "https://old.reddit.com/r/foobar/top/".
+func isSubredditURL(getURL string) bool {
+	u, err := url.Parse(getURL)
+	if err != nil {
+		return false
+	}
+	segments := splitPath(u.Path)
+	return len(segments) >= 2 && strings.EqualFold(segments[0], "r")
+}
+
+// detectSubredditState looks for the messages old.reddit shows in the
+// interstitials of unavailable subreddits. 'text' must be the interstitial's
+// text (see detectInterstitial), since the same words can appear anywhere
+// else on a page. It returns nil if none are found.
+func detectSubredditState(text string) error {
+	text = strings.ToLower(text)
+
+	switch {
+	case strings.Contains(text, "quarantined"):
+		return ErrSubredditQuarantined
+	case strings.Contains(text, "has been banned"),
+		strings.Contains(text, "was banned"):
+		return ErrSubredditBanned
+	case strings.Contains(text, "private community"),
+		strings.Contains(text, "subreddit is private"):
+		return ErrSubredditPrivate
+	default:
+		return nil
+	}
+}
+
+// ------------------------------------------------------------------------- //
+// Error -> user facing response mapping
+// ------------------------------------------------------------------------- //
+
+// describeError maps an error returned by the parser to the status code and
+// user facing message that should be shown for it.
+func describeError(err error) ErrorInfo {
+
+	// 'key' identifies the message in the catalog (see ErrorInfo.MessageKey).
+	// The English message is kept for JSON clients.
+	info := func(statusCode int, code string, key string, message string, args ...any) ErrorInfo {
+		if len(args) > 0 {
+			message = fmt.Sprintf(message, args...)
+		}
+		return ErrorInfo{
+			StatusCode:  statusCode,
+			Code:        code,
+			Title:       http.StatusText(statusCode),
+			Message:     message,
+			MessageKey:  key,
+			MessageArgs: args,
+		}
+	}
+
+	switch {
+	case isTimeout(err):
+		return info(http.StatusGatewayTimeout, ErrorCodeTimeout,
+			"error.timeout", "Reddit took too long to respond. Please try again.")
+	case errors.Is(err, ErrSubredditPrivate):
+		return info(http.StatusForbidden, ErrorCodeSubredditPrivate,
+			"error.subreddit_private", "This subreddit is private. Only approved members can view it.")
+	case errors.Is(err, ErrSubredditQuarantined):
+		return info(http.StatusForbidden, ErrorCodeSubredditQuarantined,
+			"error.subreddit_quarantined", "This subreddit has been quarantined by Reddit.")
+	case errors.Is(err, ErrOver18Required):
+		return info(http.StatusForbidden, ErrorCodeOver18Required,
+			"error.over18_required", "This subreddit contains adult content and requires confirming that you are over 18.")
+	case errors.Is(err, ErrSubredditBanned):
+		return info(http.StatusGone, ErrorCodeSubredditBanned,
+			"error.subreddit_banned", "This subreddit has been banned by Reddit.")
+	case errors.Is(err, ErrSubredditNotFound):
+		return info(http.StatusNotFound, ErrorCodeNotFound,
+			"error.subreddit_not_found", "There doesn't seem to be anything here. Check the subreddit name and try again.")
+	case errors.Is(err, ErrRouteNotFound):
+		return info(http.StatusNotFound, ErrorCodeNotFound,
+			"error.route_not_found", "This page doesn't exist.")
+	case errors.Is(err, ErrInvalidSubredditName):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.subscription.invalid_name", "That isn't a valid subreddit name.")
+	case errors.Is(err, ErrInvalidOPML):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.subscription.invalid_opml", "The file isn't a valid OPML document.")
+	case errors.Is(err, ErrInvalidExport):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.saved.invalid_export", "The file isn't a valid export of saved posts.")
+	case errors.Is(err, ErrInvalidTag):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.saved.invalid_tag", "Tags can only contain letters, numbers, '_' and '-'.")
+	case errors.Is(err, ErrNoteTooLong):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.saved.note_too_long", "The note is too long.")
+	case errors.Is(err, ErrBadRequest), errors.Is(err, ErrInvalidPostID):
+		return info(http.StatusBadRequest, ErrorCodeBadRequest,
+			"error.bad_request", "The request couldn't be understood.")
+	case errors.Is(err, ErrCrossOrigin):
+		return info(http.StatusForbidden, ErrorCodeForbidden,
+			"error.cross_origin", "This form can only be submitted from this site.")
+	case errors.Is(err, ErrNotSubscribed):
+		return info(http.StatusNotFound, ErrorCodeNotFound,
+			"error.subscription.not_subscribed", "You aren't subscribed to that subreddit.")
+	case errors.Is(err, ErrNotSaved):
+		return info(http.StatusNotFound, ErrorCodeNotFound,
+			"error.saved.not_saved", "That post isn't saved.")
+	case errors.Is(err, ErrPostNotFound):
+		return info(http.StatusNotFound, ErrorCodeNotFound,
+			"error.post_not_found", "That post doesn't exist, or has been removed.")
+	case errors.Is(err, ErrSiteTableNotFound):
+		return info(http.StatusBadGateway, ErrorCodeParseFailed,
+			"error.parse_failed", "Reddit returned a page we couldn't understand.")
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusForbidden:
+			return info(http.StatusForbidden, ErrorCodeForbidden,
+				"error.forbidden", "Reddit refused the request.")
+		case http.StatusNotFound:
+			return info(http.StatusNotFound, ErrorCodeNotFound,
+				"error.not_found", "There doesn't seem to be anything here.")
+		case http.StatusTooManyRequests:
+			return info(http.StatusTooManyRequests, ErrorCodeRateLimited,
+				"error.rate_limited", "Reddit is rate limiting us. Wait a minute and try again.")
+		default:
+			return info(http.StatusBadGateway, ErrorCodeUpstream,
+				"error.upstream_error", "Reddit returned an unexpected error (%d).", httpErr.StatusCode)
+		}
+	}
+
+	return info(http.StatusInternalServerError, ErrorCodeInternal,
+		"error.internal", "Something went wrong while loading this page.")
+}
@@ -2,4 +2,7 @@ module reddit_viewer
 
 go 1.22
 
-require golang.org/x/net v0.27.0
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/net v0.27.0
+)
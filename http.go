@@ -3,17 +3,20 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	neturl "net/url"
+	"strings"
 	"time"
 )
 
 const (
-	// Timeouts
-	defaultGlobalTimeout         = 30 * time.Second
+	// Timeouts. There's deliberately no overall timeout on the client, since
+	// each request's context carries its deadline (see -request-timeout).
 	defaultDialerTimeout         = 5 * time.Second
 	defaultTLSHandshakeTimeout   = 5 * time.Second
 	defaultResponseHeaderTimeout = 5 * time.Second
@@ -32,6 +35,17 @@ func (h HTTPError) Error() string {
 	return fmt.Sprintf("%d: %s", h.StatusCode, http.StatusText(h.StatusCode))
 }
 
+// isTimeout reports whether 'err' was caused by a deadline expiring, either
+// one attached to the request context or one enforced by the HTTP client.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func getDefaultHTTPClient() (*http.Client, error) {
 
 	// Set up a cookie jar
@@ -41,7 +55,6 @@ func getDefaultHTTPClient() (*http.Client, error) {
 	}
 
 	return &http.Client{
-		Timeout: defaultGlobalTimeout,
 		Transport: &http.Transport{
 			DialContext: (&net.Dialer{
 				Timeout: defaultDialerTimeout,
@@ -62,17 +75,46 @@ func get(
 	url string,
 	headers http.Header,
 ) ([]byte, http.Header, error) {
+	return do(ctx, client, http.MethodGet, url, headers, http.NoBody)
+}
+
+// postForm submits 'form' as an "application/x-www-form-urlencoded" body.
+func postForm(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	headers http.Header,
+	form neturl.Values,
+) ([]byte, http.Header, error) {
+
+	h := headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return do(ctx, client, http.MethodPost, url, h, strings.NewReader(form.Encode()))
+}
+
+func do(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	url string,
+	headers http.Header,
+	body io.Reader,
+) ([]byte, http.Header, error) {
 
 	// Prepare the HTTP request
-	httpRequest, err := http.NewRequestWithContext(
-		ctx, http.MethodGet, url, http.NoBody,
-	)
+	httpRequest, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, nil, err
 	}
-	httpRequest.Header = headers
+	if headers != nil {
+		httpRequest.Header = headers
+	}
 
-	// Execute the HTTP Get
+	// Execute the HTTP request
 	httpResponse, err := client.Do(httpRequest)
 	if err != nil {
 		return nil, nil, err
@@ -82,7 +124,7 @@ func get(
 	}()
 
 	// Extract the response's body
-	body, err := io.ReadAll(httpResponse.Body)
+	responseBody, err := io.ReadAll(httpResponse.Body)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -91,10 +133,10 @@ func get(
 	if httpResponse.StatusCode != http.StatusOK {
 		return nil, httpResponse.Header, &HTTPError{
 			StatusCode: httpResponse.StatusCode,
-			Body:       string(body),
+			Body:       string(responseBody),
 		}
 	}
 
 	// On success, return to user
-	return body, httpResponse.Header, nil
+	return responseBody, httpResponse.Header, nil
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultLocale is used when the user hasn't asked for a supported
+	// locale, and for messages missing from other locales.
+	defaultLocale = "en"
+)
+
+// Plural categories, following the names used by Unicode CLDR. Rules for
+// other languages may use further categories (e.g. "few").
+const (
+	pluralOne   = "one"
+	pluralOther = "other"
+)
+
+// pluralRules pick the plural category for a count, keyed by base language.
+// Languages without an entry use the English rule.
+var pluralRules = map[string]func(n int) string{
+	"en": pluralRuleOneOther,
+	"de": pluralRuleOneOther,
+	"nl": pluralRuleOneOther,
+	"es": pluralRuleOneOther,
+	"it": pluralRuleOneOther,
+	"fr": pluralRuleZeroOne,
+	"pt": pluralRuleZeroOne,
+}
+
+// pluralRuleOneOther uses the singular for exactly one, e.g. "1 comment" but
+// "0 comments".
+func pluralRuleOneOther(n int) string {
+	if n == 1 {
+		return pluralOne
+	}
+	return pluralOther
+}
+
+// pluralRuleZeroOne also uses the singular for zero, e.g. "0 commentaire".
+func pluralRuleZeroOne(n int) string {
+	if n == 0 || n == 1 {
+		return pluralOne
+	}
+	return pluralOther
+}
+
+// ------------------------------------------------------------------------- //
+// Catalog
+// ------------------------------------------------------------------------- //
+
+// message is a translated string. Messages that depend on a count have one
+// form per plural category; all others only have the "other" form.
+type message map[string]string
+
+// UnmarshalJSON accepts either a plain string or an object of plural forms:
+//
+//	"feed.next": "Next",
+//	"post.comments": {"one": "%d Comment", "other": "%d Comments"}
+func (m *message) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = message{pluralOther: s}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return fmt.Errorf("message must be a string or an object of plural forms")
+	}
+	if _, ok := forms[pluralOther]; !ok {
+		return fmt.Errorf("plural message is missing the %q form", pluralOther)
+	}
+	*m = forms
+	return nil
+}
+
+// Catalog holds the translated messages for each supported locale. Each file
+// in "locales/" (e.g. "locales/de.json") defines one locale. Messages missing
+// from a locale fall back to the default locale.
+type Catalog struct {
+	fsys fs.FS
+
+	mu       sync.RWMutex
+	messages map[string]map[string]message // locale -> key -> message
+}
+
+// LoadCatalog reads the message files in 'fsys', which should contain a
+// "locales" directory.
+func LoadCatalog(fsys fs.FS) (*Catalog, error) {
+	c := &Catalog{fsys: fsys}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload re-reads every message file. If any file is invalid, the previously
+// loaded messages are kept and the error is returned.
+func (c *Catalog) Reload() error {
+
+	paths, err := fs.Glob(c.fsys, "locales/*.json")
+	if err != nil {
+		return err
+	}
+
+	messages := map[string]map[string]message{}
+	for _, p := range paths {
+		locale := strings.ToLower(strings.TrimSuffix(path.Base(p), ".json"))
+		data, err := fs.ReadFile(c.fsys, p)
+		if err != nil {
+			return err
+		}
+		var m map[string]message
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		messages[locale] = m
+	}
+	if _, ok := messages[defaultLocale]; !ok {
+		return fmt.Errorf("no messages found for the default locale (%s)", defaultLocale)
+	}
+
+	c.mu.Lock()
+	c.messages = messages
+	c.mu.Unlock()
+	return nil
+}
+
+// Locales lists the supported locales in alphabetical order.
+func (c *Catalog) Locales() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	locales := make([]string, 0, len(c.messages))
+	for locale := range c.messages {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// Translate returns the message 'key' in 'locale', formatted with 'args' as
+// in fmt.Sprintf. For plural messages, the first argument is the count that
+// selects the form. If the message doesn't exist at all, the key itself is
+// returned so the omission is easy to spot.
+func (c *Catalog) Translate(locale string, key string, args ...any) string {
+	text, ok := c.Lookup(locale, key, args...)
+	if !ok {
+		return key
+	}
+	return text
+}
+
+// Lookup is like Translate, but reports whether the message exists instead of
+// falling back to the key.
+func (c *Catalog) Lookup(locale string, key string, args ...any) (string, bool) {
+
+	c.mu.RLock()
+	m, ok := c.messages[locale][key]
+	if !ok {
+		m, ok = c.messages[defaultLocale][key]
+		locale = defaultLocale
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+
+	form := pluralOther
+	if len(m) > 1 && len(args) > 0 {
+		if n, ok := pluralCount(args[0]); ok {
+			form = pluralRule(locale)(n)
+		}
+	}
+	text, ok := m[form]
+	if !ok {
+		text = m[pluralOther]
+	}
+
+	if len(args) == 0 {
+		return text, true
+	}
+	return fmt.Sprintf(text, args...), true
+}
+
+// Negotiate picks the best supported locale for an Accept-Language header
+// (e.g. "de-AT,de;q=0.9,en;q=0.5"). Region specific tags fall back to their
+// base language. It returns false if none of the languages are supported.
+func (c *Catalog) Negotiate(acceptLanguage string) (string, bool) {
+
+	type candidate struct {
+		tag     string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{tag, quality})
+		}
+	}
+
+	// Stable, so equally preferred languages keep the client's order
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	locales := c.Locales()
+	for _, cand := range candidates {
+		if slices.Contains(locales, cand.tag) {
+			return cand.tag, true
+		}
+		if base, _, ok := strings.Cut(cand.tag, "-"); ok && slices.Contains(locales, base) {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+func pluralRule(locale string) func(n int) string {
+	base, _, _ := strings.Cut(locale, "-")
+	if rule, ok := pluralRules[base]; ok {
+		return rule
+	}
+	return pluralRuleOneOther
+}
+
+func pluralCount(arg any) (int, bool) {
+	switch n := arg.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case int32:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
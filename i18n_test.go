@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func newTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{
+			"greeting": "Hello, %s",
+			"items": {"one": "%d item", "other": "%d items"},
+			"only.en": "Only in English",
+			"plain": "%d things"
+		}`)},
+		"locales/de.json": {Data: []byte(`{
+			"greeting": "Hallo, %s",
+			"items": {"one": "%d Eintrag", "other": "%d Einträge"}
+		}`)},
+		"locales/fr.json": {Data: []byte(`{
+			"items": {"one": "%d élément", "other": "%d éléments"}
+		}`)},
+		"locales/pt-BR.json": {Data: []byte(`{
+			"items": {"one": "%d item", "other": "%d itens"}
+		}`)},
+	}
+	catalog, err := LoadCatalog(fsys)
+	if err != nil {
+		t.Fatalf("failed to load messages: %v", err)
+	}
+	return catalog
+}
+
+func TestPluralRule(t *testing.T) {
+	tests := []struct {
+		locale string
+		n      int
+		want   string
+	}{
+		{"en", 0, pluralOther},
+		{"en", 1, pluralOne},
+		{"en", 2, pluralOther},
+		{"de", 1, pluralOne},
+		{"de", 0, pluralOther},
+		{"fr", 0, pluralOne},
+		{"fr", 1, pluralOne},
+		{"fr", 2, pluralOther},
+		{"fr-ca", 0, pluralOne},
+		{"pt-br", 0, pluralOne},
+
+		// Unknown languages use the English rule
+		{"xx", 0, pluralOther},
+		{"xx", 1, pluralOne},
+	}
+
+	for _, test := range tests {
+		if got := pluralRule(test.locale)(test.n); got != test.want {
+			t.Errorf("pluralRule(%q)(%d) = %q, want %q", test.locale, test.n, got, test.want)
+		}
+	}
+}
+
+func TestCatalogTranslate(t *testing.T) {
+	catalog := newTestCatalog(t)
+
+	tests := []struct {
+		locale string
+		key    string
+		args   []any
+		want   string
+	}{
+		{"en", "greeting", []any{"you"}, "Hello, you"},
+		{"de", "greeting", []any{"du"}, "Hallo, du"},
+
+		// Plural forms follow the locale's rule
+		{"en", "items", []any{0}, "0 items"},
+		{"en", "items", []any{1}, "1 item"},
+		{"en", "items", []any{int64(2)}, "2 items"},
+		{"de", "items", []any{1}, "1 Eintrag"},
+		{"de", "items", []any{0}, "0 Einträge"},
+		{"fr", "items", []any{0}, "0 élément"},
+		{"fr", "items", []any{2}, "2 éléments"},
+		{"pt-br", "items", []any{0}, "0 item"},
+
+		// Messages only have one form unless they say otherwise
+		{"en", "plain", []any{1}, "1 things"},
+
+		// Missing messages and locales fall back to the default locale, and
+		// its plural rule
+		{"de", "only.en", nil, "Only in English"},
+		{"fr", "greeting", []any{"toi"}, "Hello, toi"},
+		{"xx", "items", []any{1}, "1 item"},
+		{"xx", "items", []any{0}, "0 items"},
+
+		// Unknown keys are returned as they are
+		{"de", "missing.key", nil, "missing.key"},
+	}
+
+	for _, test := range tests {
+		if got := catalog.Translate(test.locale, test.key, test.args...); got != test.want {
+			t.Errorf("Translate(%q, %q, %v) = %q, want %q", test.locale, test.key, test.args, got, test.want)
+		}
+	}
+
+	if _, ok := catalog.Lookup("en", "missing.key"); ok {
+		t.Errorf("Lookup(en, missing.key) found a message")
+	}
+}
+
+func TestCatalogNegotiate(t *testing.T) {
+	catalog := newTestCatalog(t)
+
+	tests := []struct {
+		acceptLanguage string
+		want           string // empty if nothing is supported
+	}{
+		{"de", "de"},
+		{"EN-us", "en"},
+		{"pt-BR", "pt-br"},
+
+		// Quality values decide, not the order
+		{"de-AT;q=0.8,en;q=0.5", "de"},
+		{"en;q=0.5,de-AT;q=0.8", "de"},
+		{"es,fr-CA;q=0.7,de;q=0.9", "de"},
+		{"de;q=0,en", "en"},
+		{"de;q=invalid,en;q=0.1", "en"},
+
+		// Equal qualities keep the client's order
+		{"fr, de", "fr"},
+		{"de;q=0.5,fr;q=0.5", "de"},
+
+		// Region specific tags fall back to their base language
+		{"de-CH", "de"},
+		{"es-ES,fr-CA;q=0.2", "fr"},
+
+		{"", ""},
+		{"*", ""},
+		{"es,it;q=0.8", ""},
+	}
+
+	for _, test := range tests {
+		got, ok := catalog.Negotiate(test.acceptLanguage)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("Negotiate(%q) = %q, %t, want %q", test.acceptLanguage, got, ok, test.want)
+		}
+	}
+}
+
+func TestLoadCatalogRequiresDefaultLocale(t *testing.T) {
+	fsys := fstest.MapFS{
+		"locales/de.json": {Data: []byte(`{"greeting": "Hallo"}`)},
+	}
+	if _, err := LoadCatalog(fsys); err == nil {
+		t.Errorf("LoadCatalog succeeded without messages for %q", defaultLocale)
+	}
+}
+
+func TestCatalogReloadKeepsMessagesOnError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"greeting": "Hello"}`)},
+	}
+	catalog, err := LoadCatalog(fsys)
+	if err != nil {
+		t.Fatalf("failed to load messages: %v", err)
+	}
+
+	fsys["locales/en.json"] = &fstest.MapFile{Data: []byte(`{"greeting": {"one": "missing the other form"}}`)}
+	if err := catalog.Reload(); err == nil {
+		t.Errorf("Reload succeeded with an invalid message")
+	}
+	if got := catalog.Translate("en", "greeting"); got != "Hello" {
+		t.Errorf("after a failed Reload, Translate = %q, want the previous message", got)
+	}
+}
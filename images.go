@@ -0,0 +1,157 @@
+package main
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minResponsiveImageWidth is the smallest image worth showing in place of
+	// the original. Anything smaller is just a thumbnail.
+	minResponsiveImageWidth = 320
+)
+
+// findImageVariants collects the renditions of a post's image that Reddit
+// offers, from smallest to largest. Only renditions with known dimensions are
+// included, since they're used to build srcset attributes.
+//
+// The smallest is usually the thumbnail:
+//
+//	<a class="thumbnail ..." href="...">
+//	  <img src="//b.thumbs.redditmedia.com/[ID].jpg" width="70" height="52">
+//	</a>
+//
+// Larger ones are previews in the expando's cached HTML. Each preview may list
+// further resolutions in a srcset, in which case the preview's own width and
+// height give the aspect ratio:
+//
+//	<div class="media-preview-content">
+//	  <a href="https://i.redd.it/[ID].jpg">
+//	    <img class="preview" width="640" height="480"
+//	         src="https://preview.redd.it/[ID].jpg?width=640&..."
+//	         srcset="https://preview.redd.it/[ID].jpg?width=108&... 108w, ...">
+//	  </a>
+//	</div>
+//
+// The previews of animated posts (e.g. GIFs) are still frames, so only the
+// thumbnail is kept for those. The animation itself is shown instead.
+func findImageVariants(n *html.Node, animated bool) []ImageVariant {
+
+	var variants []ImageVariant
+	add := func(src string, width int, height int) {
+		if src == "" || width <= 0 || height <= 0 {
+			return
+		}
+		if strings.HasPrefix(src, "//") {
+			src = "https:" + src
+		}
+		variants = append(variants, ImageVariant{URL: src, Width: width, Height: height})
+	}
+
+	if thumbnail, err := findThumbnailImage(n); err == nil {
+		src, _ := GetAttribute(thumbnail, "src")
+		add(src, intAttribute(thumbnail, "width"), intAttribute(thumbnail, "height"))
+	}
+
+	if expando, err := findExpando(n); err == nil && !animated {
+		previews := FindAll(expando,
+			And(
+				IsTag(atom.Img),
+				HasClass("preview"),
+			),
+			RecurseAlways,
+		)
+		for _, preview := range previews {
+			src, _ := GetAttribute(preview, "src")
+			width, height := intAttribute(preview, "width"), intAttribute(preview, "height")
+			add(src, width, height)
+
+			set, _ := GetAttribute(preview, "srcset")
+			for _, candidate := range parseSrcset(set) {
+				if width > 0 {
+					add(candidate.URL, candidate.Width, candidate.Width*height/width)
+				}
+			}
+		}
+	}
+
+	// Smallest first, and one variant per width
+	slices.SortStableFunc(variants, func(a, b ImageVariant) int {
+		return a.Width - b.Width
+	})
+	return slices.CompactFunc(variants, func(a, b ImageVariant) bool {
+		return a.Width == b.Width
+	})
+}
+
+// isGIF reports whether 'link' points at a GIF, which may be animated.
+func isGIF(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(animatedExtensions, strings.ToLower(path.Ext(u.Path)))
+}
+
+// parseSrcset parses the width descriptors of a srcset attribute, e.g.
+// "a.jpg 320w, b.jpg 640w". Candidates without a width are skipped.
+func parseSrcset(srcset string) []ImageVariant {
+	var candidates []ImageVariant
+	for _, part := range strings.Split(srcset, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 || !strings.HasSuffix(fields[1], "w") {
+			continue
+		}
+		width, err := strconv.Atoi(strings.TrimSuffix(fields[1], "w"))
+		if err != nil || width <= 0 {
+			continue
+		}
+		candidates = append(candidates, ImageVariant{URL: fields[0], Width: width})
+	}
+	return candidates
+}
+
+func intAttribute(n *html.Node, key string) int {
+	value, _ := GetAttribute(n, key)
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// ------------------------------------------------------------------------- //
+// Template Helpers
+// ------------------------------------------------------------------------- //
+
+// responsiveImages returns 'variants' if they're worth offering in a srcset,
+// i.e. if there's more than a thumbnail. Otherwise, it returns nil.
+func responsiveImages(variants []ImageVariant) []ImageVariant {
+	if largestImage(variants).Width < minResponsiveImageWidth {
+		return nil
+	}
+	return variants
+}
+
+// srcset formats 'variants' as the value of a srcset attribute.
+func srcset(variants []ImageVariant) string {
+	candidates := make([]string, len(variants))
+	for i, v := range variants {
+		candidates[i] = v.URL + " " + strconv.Itoa(v.Width) + "w"
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// largestImage returns the last (largest) of 'variants', which is used as the
+// src of an <img> for browsers that don't support srcset.
+func largestImage(variants []ImageVariant) ImageVariant {
+	if len(variants) == 0 {
+		return ImageVariant{}
+	}
+	return variants[len(variants)-1]
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
-	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
-//go:embed static
-var staticFiles embed.FS
-
-func fileServer() http.Handler {
-	return http.FileServer(http.FS(staticFiles))
+// fileServer serves the "static" directory of 'fsys' under "/static/".
+func fileServer(fsys fs.FS) http.Handler {
+	return http.FileServer(http.FS(fsys))
 }
 
 func loggingHandler(h http.Handler) http.Handler {
@@ -25,150 +29,599 @@ func loggingHandler(h http.Handler) http.Handler {
 	})
 }
 
+// sameOriginHandler rejects requests that change state (anything but GET,
+// HEAD and OPTIONS) unless they were sent by one of our own pages (see
+// isSameOrigin). Rejected requests get a JSON error under "/api/", and an error
+// page otherwise.
+func sameOriginHandler(registry *TemplateRegistry, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !isSameOrigin(r) {
+				outputJSON := strings.HasPrefix(r.URL.Path, "/api/")
+				writeError(w, r, registry, outputJSON, describeError(ErrCrossOrigin))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isSameOrigin reports whether 'r' was sent by one of our own pages, so other
+// sites can't submit forms on the user's behalf. Browsers say so in
+// Sec-Fetch-Site, or else in the Origin (or Referer) header. Requests without
+// any of these (e.g. from curl) don't come from a web page, and are allowed.
+func isSameOrigin(r *http.Request) bool {
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
 type ProxyHandler struct {
-	Parser *RedditParser
+	Parser    *RedditParser
+	Templates *TemplateRegistry
+
+	// RequestTimeout bounds how long a single request may spend fetching and
+	// parsing upstream content. Zero means no deadline beyond the lifetime of
+	// the client's connection.
+	RequestTimeout time.Duration
+
+	// Images, if set, serves resized copies of post images, which HTML feeds
+	// then offer instead of Reddit's own sizes.
+	Images *ImageResizer
+
+	// Seen, if set, tracks the posts the user has seen, which feeds then
+	// mark or hide (see SeenPolicy).
+	Seen *SeenStore
+
+	// Saved, if set, is used to mark the posts the user has saved, so feeds
+	// can offer to unsave them instead.
+	Saved *SavedStore
 }
 
 // ServeHTTP is the main request router for Reddit traffic. For feeds (front
 // page and individual subreddits), we support:
 //   - Sort Method (e.g. "hot", "top", etc.)
-//   - Paging (e.g. "after=abcd")
+//   - Paging (e.g. "after=abcd" or "before=abcd", with an optional "count")
 //   - JSON output (if the URL ends with ".json")
 //
+// Routes mirror old Reddit's URL scheme (see feedRouteTable). Canonical paths
+// are lower case with a trailing slash; HTML requests for any other spelling
+// of a route (e.g. "/R/FooBar/Top") are redirected to the canonical path.
+//
 // Front Page Routes:
 //
 //	[root]/
-//	[root]/[sort_method]
+//	[root]/.json
+//	[root]/[sort_method]/
 //	[root]/[sort_method].json
-//	[root]/?after=[last_post_id]
-//	[root]/?after=[last_post_id].json
-//	[root]/[sort_method]/?after=[last_post_id]
-//	[root]/[sort_method]/?after=[last_post_id].json
+//	[root]/?after=[last_post_id]&count=[count]
+//	[root]/?after=[last_post_id]&count=[count].json
+//	[root]/[sort_method]/?before=[first_post_id]&count=[count]
+//	[root]/[sort_method]/?before=[first_post_id]&count=[count].json
 //
 // Subreddit Feed Routes:
 //
-//	[root]/r/foobar
+//	[root]/r/foobar/
 //	[root]/r/foobar.json
-//	[root]/r/foobar/[sort_method]
+//	[root]/r/foobar/[sort_method]/
 //	[root]/r/foobar/[sort_method].json
-//	[root]/r/foobar/?after=[last_post_id]
-//	[root]/r/foobar/?after=[last_post_id].json
-//	[root]/r/foobar/[sort_method]/?after=[last_post_id]
-//	[root]/r/foobar/[sort_method]/?after=[last_post_id].json
-//
-// Comments Routes:
-//
-//	[root]/r/foobar/comments/[post_id]/[some_title]/
-//	[root]/r/foobar/comments/[post_id]/[some_title].json
+//	[root]/r/foobar/?after=[last_post_id]&count=[count]
+//	[root]/r/foobar/?after=[last_post_id]&count=[count].json
+//	[root]/r/foobar/[sort_method]/?before=[first_post_id]&count=[count]
+//	[root]/r/foobar/[sort_method]/?before=[first_post_id]&count=[count].json
 func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	outputJSON := false
+
 	// Make sure we can recover gracefully from a panic
 	defer func() {
 		if e := recover(); e != nil {
 			logF(LevelError, "Recovered from panic: %v", e)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeError(w, r, ph.Templates, outputJSON, describeError(fmt.Errorf("panic: %v", e)))
 		}
 	}()
 
+	// Remember the original URL so error pages can offer a retry link
+	retryLink := r.URL.RequestURI()
+
 	// Work out if the user intends for us to return JSON output or HTML
-	outputJSON := false
+	outputJSON = trimJSONSuffix(r)
+
+	// Work out which feed is being requested
+	route, err := matchFeedRoute(r.URL.Path)
+	if err != nil {
+		writeError(w, r, ph.Templates, outputJSON, describeError(err))
+		return
+	}
+
+	// Send browsers to the canonical spelling of the route. JSON clients are
+	// served as-is rather than being bounced around.
+	if canonical := route.Path(); !outputJSON && r.URL.Path != canonical &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		target := canonical
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	ph.serveFeed(w, r, route, outputJSON, retryLink, nil)
+}
+
+// trimJSONSuffix reports whether 'r' asks for JSON output, which is signalled
+// by a ".json" suffix on the path or the query. The suffix is removed.
+func trimJSONSuffix(r *http.Request) bool {
 	if strings.HasSuffix(r.URL.Path, ".json") {
-		outputJSON = true
 		r.URL.Path = strings.TrimSuffix(r.URL.Path, ".json")
+		return true
 	} else if strings.HasSuffix(r.URL.RawQuery, ".json") {
-		outputJSON = true
 		r.URL.RawQuery = strings.TrimSuffix(r.URL.RawQuery, ".json")
+		return true
 	}
+	return false
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// serveFeed fetches the feed for 'route' and writes it as JSON or HTML. If
+// 'adjust' isn't nil, it's given the feed to modify before it's written.
+func (ph *ProxyHandler) serveFeed(
+	w http.ResponseWriter,
+	r *http.Request,
+	route feedRoute,
+	outputJSON bool,
+	retryLink string,
+	adjust func(feed *Feed),
+) {
+
+	// Derive the upstream context from the client's request so that work is
+	// abandoned as soon as the client goes away.
+	ctx := r.Context()
+	if ph.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ph.RequestTimeout)
+		defer cancel()
+	}
 
 	// Invoke the parser to download the desired feed
-	feed, err := ph.Parser.Feed(ctx, parseFeedOptions(r)...)
+	feed, err := ph.Parser.Feed(ctx, parseFeedOptions(r, route)...)
 	if err != nil {
-		logF(LevelError, "Failed to retrieve feed: %v", err)
-		statusCode := http.StatusInternalServerError
-		if httpErr, ok := err.(*HTTPError); ok {
-			statusCode = httpErr.StatusCode
+
+		// There's nobody left to respond to if the client disconnected
+		if r.Context().Err() != nil {
+			logF(LevelDebug, "Client went away: %v", err)
+			return
 		}
-		w.WriteHeader(statusCode)
+
+		logF(LevelError, "Failed to retrieve feed: %v", err)
+		info := describeError(err)
+		info.RetryLink = retryLink
+		writeError(w, r, ph.Templates, outputJSON, info)
 		return
 	}
 
+	if adjust != nil {
+		adjust(feed)
+	}
+
+	// Seen posts are removed before either kind of output, so that they never
+	// reach the client
+	prefs := readPreferences(r, ph.Templates)
+	if ph.Seen != nil {
+		applySeenPolicy(feed, ph.Seen, prefs.Seen)
+	}
+	if ph.Saved != nil {
+		ph.Saved.markSaved(feed)
+	}
+
+	ph.writeFeed(w, r, FeedPage{Feed: feed, Prefs: prefs, TrackSeen: ph.Seen != nil}, outputJSON)
+}
+
+// writeFeed writes the feed of 'page' as JSON or HTML, after applying the
+// media policies of page.Prefs.
+func (ph *ProxyHandler) writeFeed(
+	w http.ResponseWriter,
+	r *http.Request,
+	page FeedPage,
+	outputJSON bool,
+) {
+
+	// Sensitive media is removed before either kind of output, so that it
+	// never reaches the client
+	feed := page.Feed
+	applyMediaPolicies(feed, page.Prefs)
+
 	// Render as JSON
 	if outputJSON {
-		out, err := json.MarshalIndent(feed, "", "  ")
-		if err != nil {
-			logF(LevelError, "Failed to generate JSON: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		_, _ = w.Write(out)
+		writeJSON(w, r, ph.Templates, feed)
 		return
 	}
 
 	// Render as HTML
-	out, err := renderFeed(feed)
+	if ph.Images != nil {
+		ph.Images.ResizeFeed(feed)
+	}
+	savePreferences(w, r, page.Prefs)
+	w.Header().Add("Vary", "Accept-Language")
+	out, err := ph.Templates.renderFeed(page)
 	if err != nil {
 		logF(LevelError, "Failed to render feed: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, ph.Templates, outputJSON, describeError(err))
+		return
+	}
+	_, _ = w.Write(out)
+}
+
+// writeJSON writes 'v' as indented JSON, or reports an error if it can't be
+// encoded.
+func writeJSON(w http.ResponseWriter, r *http.Request, registry *TemplateRegistry, v any) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logF(LevelError, "Failed to generate JSON: %v", err)
+		writeError(w, r, registry, true, describeError(err))
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(out)
 }
 
-func parseFeedOptions(r *http.Request) []FeedOption {
+// writeError reports a failed request to the user, either as a JSON error
+// envelope or as a rendered HTML page. If the page itself can't be rendered,
+// the bare status code is still returned.
+func writeError(
+	w http.ResponseWriter,
+	r *http.Request,
+	registry *TemplateRegistry,
+	outputJSON bool,
+	info ErrorInfo,
+) {
+
+	var out []byte
+	var err error
+	if outputJSON {
+		w.Header().Set("Content-Type", "application/json")
+		out, err = json.MarshalIndent(ErrorResponse{Error: info}, "", "  ")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		out, err = registry.renderError(info, readPreferences(r, registry))
+	}
+	if err != nil {
+		logF(LevelError, "Failed to render error: %v", err)
+		w.Header().Del("Content-Type")
+		w.WriteHeader(info.StatusCode)
+		return
+	}
+
+	w.WriteHeader(info.StatusCode)
+	_, _ = w.Write(out)
+}
+
+func parseFeedOptions(r *http.Request, route feedRoute) []FeedOption {
 
 	var options []FeedOption
 
-	// Subreddit and sort method. Note that "/r/foobar/hot" is parsed as:
-	// ["", "r", "foobar", "hot"] (with an empty first element).
-	pieces := strings.Split(r.URL.Path, "/")
-	if len(pieces) >= 3 && pieces[1] == "r" {
-		options = append(options, WithSubreddit(pieces[2]))
-	}
-	if len(pieces) > 0 {
-		if sm, err := SortMethodFromString(pieces[len(pieces)-1]); err == nil {
-			options = append(options, WithSortMethod(sm))
-		}
+	// Subreddit and sort method
+	if route.Subreddit != nil {
+		options = append(options, WithSubreddit(strings.ToLower(*route.Subreddit)))
 	}
+	options = append(options, WithSortMethod(route.SortMethod))
 
-	// Last Post ID
-	if lastPostID := r.URL.Query().Get("after"); lastPostID != "" {
+	// Paging
+	query := r.URL.Query()
+	if lastPostID := query.Get("after"); lastPostID != "" {
 		options = append(options, WithLastPostID(lastPostID))
+	} else if firstPostID := query.Get("before"); firstPostID != "" {
+		options = append(options, WithFirstPostID(firstPostID))
+	}
+	if count, err := strconv.Atoi(query.Get("count")); err == nil && count > 0 {
+		options = append(options, WithCount(count))
 	}
 
-	// Headers
-	//   - NOTE: This parser doesn't currently handle 'gzip' or other
-	//     compressed formats.
-	headers := r.Header
-	headers.Del("Accept-Encoding")
-	options = append(options, WithHeaders(headers))
+	options = append(options, WithHeaders(upstreamHeaders(r)))
 
 	return options
 }
 
+// upstreamHeaders returns the headers from 'r' that should be forwarded to
+// Reddit.
+func upstreamHeaders(r *http.Request) http.Header {
+
+	// NOTE: This parser doesn't currently handle 'gzip' or other compressed
+	// formats.
+	headers := r.Header.Clone()
+	headers.Del("Accept-Encoding")
+
+	// Headers describing the local request body don't apply upstream
+	headers.Del("Content-Type")
+	headers.Del("Content-Length")
+
+	return headers
+}
+
+// ConsentHandler accepts the local consent form shown for NSFW and
+// quarantined subreddits. It submits the opt-in to Reddit and then redirects
+// back to the feed. It's mounted behind sameOriginHandler, so other sites
+// can't opt the user in.
+//
+//	POST [root]/consent
+//	  kind=[over18|quarantine]&subreddit=[name]&dest=[local_url]
+type ConsentHandler struct {
+	Parser    *RedditParser
+	Templates *TemplateRegistry
+}
+
+func (ch *ConsentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, r, ch.Templates, false, ErrorInfo{
+			StatusCode: http.StatusMethodNotAllowed,
+			Code:       ErrorCodeMethodNotAllowed,
+			Title:      http.StatusText(http.StatusMethodNotAllowed),
+			Message:    "Consent must be submitted from the consent page.",
+			MessageKey: "error.consent.method",
+		})
+		return
+	}
+
+	badRequest := func(key string, message string) {
+		writeError(w, r, ch.Templates, false, ErrorInfo{
+			StatusCode: http.StatusBadRequest,
+			Code:       ErrorCodeBadRequest,
+			Title:      http.StatusText(http.StatusBadRequest),
+			Message:    message,
+			MessageKey: key,
+		})
+	}
+
+	kind, err := ConsentKindFromString(r.PostFormValue("kind"))
+	if err != nil {
+		badRequest("error.consent.kind", "Unknown consent type.")
+		return
+	}
+	subreddit := r.PostFormValue("subreddit")
+	if subreddit == "" {
+		badRequest("error.consent.subreddit", "No subreddit was provided.")
+		return
+	}
+
+	// Only allow redirects back to this server
+	dest := r.PostFormValue("dest")
+	if !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") {
+		dest = feedRoute{Subreddit: &subreddit}.Path()
+	}
+
+	err = ch.Parser.Consent(r.Context(), kind,
+		WithSubreddit(subreddit),
+		WithHeaders(upstreamHeaders(r)),
+	)
+	if err != nil {
+		logF(LevelError, "Failed to submit consent: %v", err)
+		info := describeError(err)
+		info.RetryLink = dest
+		writeError(w, r, ch.Templates, false, info)
+		return
+	}
+
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+)
+
+type Config struct {
+	Server         ServerConfig
+	RequestTimeout time.Duration
+	ConsentPolicy  ConsentPolicy
+	FetchSelfText  bool
+	FetchRules     bool
+	DevDir         string
+	OverlayDir     string
+	TimeZone       *time.Location
+	ImageCacheDir  string
+	ImageCacheSize int64
+	DataDir        string
+	SeenExpiry     time.Duration
+}
+
+func parseFlags() Config {
+
+	var cfg Config
+	flag.StringVar(&cfg.Server.Addr, "addr", ":8080",
+		"TCP address to listen on")
+	flag.StringVar(&cfg.Server.UnixSocket, "unix-socket", "",
+		"listen on this Unix socket instead of a TCP address")
+	flag.StringVar(&cfg.Server.TLSCertFile, "tls-cert", "",
+		"path to a PEM encoded TLS certificate")
+	flag.StringVar(&cfg.Server.TLSKeyFile, "tls-key", "",
+		"path to the PEM encoded private key for -tls-cert")
+	flag.BoolVar(&cfg.Server.TLSSelfSigned, "tls-self-signed", false,
+		"serve HTTPS using a generated self-signed certificate (development only)")
+	flag.DurationVar(&cfg.RequestTimeout, "request-timeout", defaultRequestTimeout,
+		"deadline for fetching and parsing a single page from Reddit, which also sets the server's write timeout (0 disables both)")
+	consentPolicy := flag.String("consent-policy", ConsentPolicyPrompt.String(),
+		"how to handle NSFW/quarantine interstitials: prompt, accept or deny")
+	flag.BoolVar(&cfg.FetchSelfText, "fetch-selftext", false,
+		"download comments pages for text posts whose body isn't in the feed page")
+	flag.BoolVar(&cfg.FetchRules, "fetch-rules", false,
+		"download each subreddit's rules page to show alongside its side bar")
+	flag.StringVar(&cfg.DevDir, "dev", "",
+		"load templates and static files from this directory (e.g. \".\") and reload them on change")
+	flag.StringVar(&cfg.OverlayDir, "overlay", "",
+		"directory whose templates/ and static/ files take precedence over the built-in ones")
+	timeZone := flag.String("timezone", "Local",
+		"IANA time zone for absolute timestamps (e.g. \"Europe/Berlin\" or \"UTC\")")
+	flag.StringVar(&cfg.ImageCacheDir, "image-cache", "",
+		"serve resized post images from /img, caching them in this directory")
+	flag.Int64Var(&cfg.ImageCacheSize, "image-cache-size", defaultImageCacheBytes>>20,
+		"size in MiB that -image-cache is trimmed to, deleting the least recently used images (0 means no limit)")
+	flag.StringVar(&cfg.DataDir, "data-dir", defaultDataDir(),
+		"directory for local data, such as subscriptions")
+	flag.DurationVar(&cfg.SeenExpiry, "seen-expiry", defaultSeenExpiry,
+		"how long posts stay marked as seen (0 disables read tracking)")
+	flag.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout,
+		"how long to wait for in-flight requests when shutting down")
+	flag.Parse()
+
+	var err error
+	cfg.ConsentPolicy, err = ConsentPolicyFromString(*consentPolicy)
+	if err != nil {
+		failF("invalid -consent-policy: %v", err)
+	}
+	cfg.TimeZone, err = time.LoadLocation(*timeZone)
+	if err != nil {
+		failF("invalid -timezone: %v", err)
+	}
+
+	if cfg.RequestTimeout < 0 {
+		failF("-request-timeout can't be negative")
+	}
+	cfg.Server.WriteTimeout = writeTimeoutFor(cfg.RequestTimeout)
+
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		failF("-tls-cert and -tls-key must be provided together")
+	}
+	if cfg.Server.TLSSelfSigned && cfg.Server.TLSCertFile != "" {
+		failF("-tls-self-signed cannot be combined with -tls-cert/-tls-key")
+	}
+
+	return cfg
+}
+
+// defaultDataDir returns the directory local data is kept in, unless -data-dir
+// says otherwise: "reddit-viewer" in the user's config directory, or "data" in
+// the working directory if there isn't one.
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "data"
+	}
+	return filepath.Join(dir, "reddit-viewer")
+}
+
 func main() {
 
+	// Subcommands take their own flags
+	if len(os.Args) > 1 && os.Args[1] == "crawl" {
+		runCrawl(os.Args[2:])
+		return
+	}
+
+	cfg := parseFlags()
+
 	client, err := getDefaultHTTPClient()
 	if err != nil {
 		failF("failed to get default http client: %v", err)
 	}
 
+	// SIGINT/SIGTERM trigger a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Assets are embedded by default. In dev mode they're read from disk
+	// instead, and the templates and messages are reloaded whenever they
+	// change. Either way, files in the overlay directory take precedence.
+	assets := fs.FS(embeddedAssets)
+	if cfg.DevDir != "" {
+		assets = os.DirFS(cfg.DevDir)
+	}
+	if cfg.OverlayDir != "" {
+		assets = newOverlayFS(os.DirFS(cfg.OverlayDir), assets)
+	}
+	messages, err := LoadCatalog(assets)
+	if err != nil {
+		failF("failed to load messages: %v", err)
+	}
+	timeFormatter := &TimeFormatter{
+		Messages: messages,
+		Clock:    SystemClock,
+		Location: cfg.TimeZone,
+	}
+	registry, err := NewTemplateRegistry(assets, messages, timeFormatter)
+	if err != nil {
+		failF("failed to parse templates: %v", err)
+	}
+	static := fileServer(assets)
+	if cfg.DevDir != "" {
+		logF(LevelInfo, "Dev mode: serving templates and static files from %s", cfg.DevDir)
+		go watchFiles(ctx, assets, "templates", defaultWatchInterval, func() {
+			reloadTemplates(registry)
+		})
+		go watchFiles(ctx, assets, "locales", defaultWatchInterval, func() {
+			reloadMessages(messages, registry)
+		})
+		static = noCacheHandler(static)
+	}
+
+	parser := &RedditParser{
+		Client:               client,
+		ConsentPolicy:        cfg.ConsentPolicy,
+		FetchMissingSelfText: cfg.FetchSelfText,
+		FetchSubredditRules:  cfg.FetchRules,
+	}
 	server := &ProxyHandler{
-		Parser: &RedditParser{
-			Client: client,
-		},
+		Parser:         parser,
+		Templates:      registry,
+		RequestTimeout: cfg.RequestTimeout,
+	}
+
+	subscriptions, err := OpenSubscriptionStore(cfg.DataDir)
+	if err != nil {
+		failF("failed to open subscriptions: %v", err)
+	}
+	server.Saved, err = OpenSavedStore(cfg.DataDir)
+	if err != nil {
+		failF("failed to open saved posts: %v", err)
 	}
 
 	mux := http.NewServeMux()
+	if cfg.SeenExpiry > 0 {
+		server.Seen, err = OpenSeenStore(cfg.DataDir, cfg.SeenExpiry)
+		if err != nil {
+			failF("failed to open seen posts: %v", err)
+		}
+		seen := &SeenHandler{Store: server.Seen, Templates: registry}
+		mux.Handle("/api/seen", loggingHandler(sameOriginHandler(registry, seen)))
+	}
+	if cfg.ImageCacheDir != "" {
+		server.Images = &ImageResizer{Client: client, CacheDir: cfg.ImageCacheDir, MaxCacheBytes: -1}
+		if cfg.ImageCacheSize > 0 {
+			server.Images.MaxCacheBytes = cfg.ImageCacheSize << 20
+		}
+		mux.Handle("/img", loggingHandler(server.Images))
+	}
 	mux.Handle("/favicon.ico", loggingHandler(http.NotFoundHandler()))
-	mux.Handle("/static/", loggingHandler(fileServer()))
+	mux.Handle("/static/", loggingHandler(static))
+	consent := &ConsentHandler{Parser: parser, Templates: registry}
+	mux.Handle("/consent", loggingHandler(sameOriginHandler(registry, consent)))
+	home := loggingHandler(&HomeHandler{Feeds: server, Subscriptions: subscriptions})
+	mux.Handle(homePath, home)
+	mux.Handle(homePath+".json", home)
+	mux.Handle(homePath+"/", home)
+	(&SubscriptionsHandler{Store: subscriptions, Templates: registry}).Register(mux, loggingHandler)
+	(&SavedHandler{Feeds: server, Store: server.Saved}).Register(mux, loggingHandler)
 	mux.Handle("/", loggingHandler(server))
 
-	err = http.ListenAndServe(":8080", mux)
+	err = runServer(ctx, cfg.Server, mux)
 	if err != nil {
-		failF("failed to start server: %v", err)
+		failF("server failed: %v", err)
 	}
 }
 
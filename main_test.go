@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSameOriginHandler(t *testing.T) {
+	messages, err := LoadCatalog(embeddedAssets)
+	if err != nil {
+		t.Fatalf("failed to load messages: %v", err)
+	}
+	registry, err := NewTemplateRegistry(embeddedAssets, messages, &TimeFormatter{Messages: messages})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	handler := sameOriginHandler(registry, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		allowed bool
+	}{
+		{"no headers", http.MethodPost, "/subscriptions", nil, true},
+		{"same origin", http.MethodPost, "/subscriptions", map[string]string{"Sec-Fetch-Site": "same-origin"}, true},
+		{"typed in", http.MethodPost, "/subscriptions", map[string]string{"Sec-Fetch-Site": "none"}, true},
+		{"cross site", http.MethodPost, "/subscriptions", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"same site", http.MethodPost, "/subscriptions", map[string]string{"Sec-Fetch-Site": "same-site"}, false},
+		{"matching origin", http.MethodPost, "/subscriptions", map[string]string{"Origin": "http://example.com"}, true},
+		{"other origin", http.MethodPost, "/subscriptions", map[string]string{"Origin": "https://evil.test"}, false},
+		{"other referer", http.MethodPost, "/subscriptions", map[string]string{"Referer": "https://evil.test/page"}, false},
+		{"null origin", http.MethodPost, "/subscriptions", map[string]string{"Origin": "null"}, false},
+		{"API delete", http.MethodDelete, "/api/subscriptions/foo", map[string]string{"Origin": "https://evil.test"}, false},
+
+		// Reading is always allowed
+		{"cross site GET", http.MethodGet, "/subscriptions", map[string]string{"Sec-Fetch-Site": "cross-site"}, true},
+		{"cross site HEAD", http.MethodHead, "/subscriptions", map[string]string{"Origin": "https://evil.test"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if test.allowed {
+				if w.Code != http.StatusNoContent {
+					t.Errorf("status = %d, want the request to be allowed", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			isJSON := strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+			if wantJSON := strings.HasPrefix(test.target, "/api/"); isJSON != wantJSON {
+				t.Errorf("Content-Type = %q, want JSON: %t", w.Header().Get("Content-Type"), wantJSON)
+			}
+		})
+	}
+}
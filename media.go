@@ -0,0 +1,302 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Embed kinds tell the template which element to use for a MediaEmbed.
+const (
+	EmbedKindImage  = "image"
+	EmbedKindVideo  = "video"
+	EmbedKindIFrame = "iframe"
+)
+
+// ------------------------------------------------------------------------- //
+// Media Rules
+// ------------------------------------------------------------------------- //
+
+// mediaRule recognizes one kind of post. 'resolve' is given the post along
+// with its parsed PostLink (which may be nil if the link couldn't be parsed),
+// and returns ok == false if the rule doesn't apply.
+type mediaRule struct {
+	name    string
+	resolve func(post *FeedPost, u *url.URL) (t FeedPostType, embed *MediaEmbed, ok bool)
+}
+
+// mediaRules are tried in order, and the first rule that applies wins. Rules
+// that depend on post metadata come first, followed by host specific rules,
+// and finally generic rules based on the file extension. Crossposts are
+// handled separately by resolveMedia, since they wrap another post's media.
+var mediaRules = []mediaRule{
+	{"poll", resolvePoll},
+	{"text", resolveText},
+	{"gallery", resolveGallery},
+	{"reddit-video", resolveRedditVideo},
+	{"imgur", resolveImgur},
+	{"youtube", resolveYouTube},
+	{"vimeo", resolveVimeo},
+	{"streamable", resolveStreamable},
+	{"extension", resolveByExtension},
+	{"reddit-image", resolveRedditImage},
+}
+
+var (
+	imageExtensions    = []string{".jpg", ".jpeg", ".png", ".webp", ".avif", ".bmp"}
+	animatedExtensions = []string{".gif"}
+	videoExtensions    = []string{".mp4", ".webm", ".mov"}
+
+	// IDs used by embeddable providers. These are deliberately strict, since
+	// they are interpolated into iframe URLs.
+	youTubeIDRegex    = regexp.MustCompile(`^[A-Za-z0-9_-]{6,20}$`)
+	vimeoIDRegex      = regexp.MustCompile(`^[0-9]+$`)
+	streamableIDRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	imgurIDRegex      = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+)
+
+// resolveMedia determines the type of 'post' and, for posts with media we can
+// show inline, how that media should be embedded.
+func resolveMedia(post *FeedPost) (FeedPostType, *MediaEmbed) {
+
+	u, err := url.Parse(post.PostLink)
+	if err != nil {
+		u = nil
+	}
+
+	t, embed := FeedPostTypeLink, (*MediaEmbed)(nil)
+	for _, rule := range mediaRules {
+		if rt, re, ok := rule.resolve(post, u); ok {
+			t, embed = rt, re
+			break
+		}
+	}
+
+	// The media of a crosspost is still shown, but the post itself is
+	// presented as a crosspost. Polls can't be shown either way.
+	if post.CrosspostParent != nil && t != FeedPostTypePoll {
+		return FeedPostTypeCrosspost, embed
+	}
+	return t, embed
+}
+
+func resolvePoll(post *FeedPost, _ *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	return FeedPostTypePoll, nil, post.IsPoll
+}
+
+func resolveText(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+
+	// Self posts link to their own (relative) comments page
+	if u == nil || u.Host != "" || !strings.HasPrefix(u.Path, "/r/") {
+		return 0, nil, false
+	}
+	return FeedPostTypeText, nil, true
+}
+
+func resolveGallery(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || !hostIs(u, "reddit.com") || !strings.HasPrefix(u.Path, "/gallery/") {
+		return 0, nil, false
+	}
+	return FeedPostTypeGallery, nil, true
+}
+
+func resolveRedditVideo(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || !(hostIs(u, "v.redd.it") || hostIs(u, "v.reddit.com")) {
+		return 0, nil, false
+	}
+	return FeedPostTypeVideo, nil, true
+}
+
+func resolveImgur(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || !hostIs(u, "imgur.com") {
+		return 0, nil, false
+	}
+
+	segments := splitPath(u.Path)
+	if len(segments) == 0 {
+		return 0, nil, false
+	}
+
+	// Albums and galleries: imgur.com/a/[ID] or imgur.com/gallery/[ID]
+	if len(segments) == 2 && (segments[0] == "a" || segments[0] == "gallery") {
+		id := imgurAlbumID(segments[1])
+		if !imgurIDRegex.MatchString(id) {
+			return 0, nil, false
+		}
+		return FeedPostTypeEmbed, &MediaEmbed{
+			Kind:     EmbedKindIFrame,
+			Provider: "imgur",
+			URL:      "https://imgur.com/a/" + id + "/embed?pub=true",
+		}, true
+	}
+
+	if len(segments) != 1 {
+		return 0, nil, false
+	}
+	ext := strings.ToLower(path.Ext(segments[0]))
+	id := strings.TrimSuffix(segments[0], path.Ext(segments[0]))
+	if !imgurIDRegex.MatchString(id) {
+		return 0, nil, false
+	}
+
+	// .gifv is an HTML page wrapping an MP4 of the same name
+	if ext == ".gifv" || ext == ".mp4" {
+		return FeedPostTypeAnimated, &MediaEmbed{
+			Kind:     EmbedKindVideo,
+			Provider: "imgur",
+			URL:      "https://i.imgur.com/" + id + ".mp4",
+		}, true
+	}
+
+	// Single image pages (imgur.com/[ID]) serve the image directly from
+	// i.imgur.com regardless of the extension we ask for.
+	if ext == "" {
+		return FeedPostTypeImage, &MediaEmbed{
+			Kind:     EmbedKindImage,
+			Provider: "imgur",
+			URL:      "https://i.imgur.com/" + id + ".jpg",
+		}, true
+	}
+
+	// Everything else falls through to the extension rules
+	return 0, nil, false
+}
+
+func resolveYouTube(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil {
+		return 0, nil, false
+	}
+
+	var id string
+	switch {
+	case hostIs(u, "youtu.be"):
+		// youtu.be/[ID]
+		if segments := splitPath(u.Path); len(segments) == 1 {
+			id = segments[0]
+		}
+	case hostIs(u, "youtube.com"):
+		// youtube.com/watch?v=[ID], youtube.com/shorts/[ID] or
+		// youtube.com/embed/[ID]
+		segments := splitPath(u.Path)
+		switch {
+		case len(segments) == 1 && segments[0] == "watch":
+			id = u.Query().Get("v")
+		case len(segments) == 2 && (segments[0] == "shorts" || segments[0] == "embed"):
+			id = segments[1]
+		}
+	default:
+		return 0, nil, false
+	}
+
+	if !youTubeIDRegex.MatchString(id) {
+		return 0, nil, false
+	}
+	return FeedPostTypeEmbed, &MediaEmbed{
+		Kind:     EmbedKindIFrame,
+		Provider: "youtube",
+		URL:      "https://www.youtube-nocookie.com/embed/" + id,
+	}, true
+}
+
+func resolveVimeo(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || !hostIs(u, "vimeo.com") {
+		return 0, nil, false
+	}
+
+	// vimeo.com/[ID]
+	segments := splitPath(u.Path)
+	if len(segments) != 1 || !vimeoIDRegex.MatchString(segments[0]) {
+		return 0, nil, false
+	}
+	return FeedPostTypeEmbed, &MediaEmbed{
+		Kind:     EmbedKindIFrame,
+		Provider: "vimeo",
+		URL:      "https://player.vimeo.com/video/" + segments[0],
+	}, true
+}
+
+func resolveStreamable(_ *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || !hostIs(u, "streamable.com") {
+		return 0, nil, false
+	}
+
+	// streamable.com/[ID]
+	segments := splitPath(u.Path)
+	if len(segments) != 1 || !streamableIDRegex.MatchString(segments[0]) {
+		return 0, nil, false
+	}
+	return FeedPostTypeEmbed, &MediaEmbed{
+		Kind:     EmbedKindIFrame,
+		Provider: "streamable",
+		URL:      "https://streamable.com/e/" + segments[0],
+	}, true
+}
+
+func resolveByExtension(post *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+	if u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0, nil, false
+	}
+
+	// Query strings and fragments are ignored, so "x.jpg?width=640" is
+	// still recognized as an image.
+	ext := strings.ToLower(path.Ext(u.Path))
+	provider := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch {
+	case slices.Contains(imageExtensions, ext):
+		return FeedPostTypeImage, &MediaEmbed{
+			Kind:     EmbedKindImage,
+			Provider: provider,
+			URL:      post.PostLink,
+		}, true
+	case slices.Contains(animatedExtensions, ext):
+		return FeedPostTypeAnimated, &MediaEmbed{
+			Kind:     EmbedKindImage,
+			Provider: provider,
+			URL:      post.PostLink,
+		}, true
+	case slices.Contains(videoExtensions, ext):
+		return FeedPostTypeEmbed, &MediaEmbed{
+			Kind:     EmbedKindVideo,
+			Provider: provider,
+			URL:      post.PostLink,
+		}, true
+	default:
+		return 0, nil, false
+	}
+}
+
+func resolveRedditImage(post *FeedPost, u *url.URL) (FeedPostType, *MediaEmbed, bool) {
+
+	// Everything on i.redd.it is an image, even without a known extension
+	if u == nil || !hostIs(u, "i.redd.it") {
+		return 0, nil, false
+	}
+	return FeedPostTypeImage, &MediaEmbed{
+		Kind:     EmbedKindImage,
+		Provider: "i.redd.it",
+		URL:      post.PostLink,
+	}, true
+}
+
+// ------------------------------------------------------------------------- //
+// Helpers
+// ------------------------------------------------------------------------- //
+
+// hostIs reports whether the host of 'u' is 'domain' or one of its
+// subdomains (e.g. "m.youtube.com" for "youtube.com").
+func hostIs(u *url.URL, domain string) bool {
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// imgurAlbumID strips the optional title prefix from an album slug, e.g.
+// "funny-cats-AbC123" becomes "AbC123".
+func imgurAlbumID(slug string) string {
+	if i := strings.LastIndex(slug, "-"); i >= 0 {
+		return slug[i+1:]
+	}
+	return slug
+}
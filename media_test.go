@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveMedia(t *testing.T) {
+	iframe := func(provider string, url string) *MediaEmbed {
+		return &MediaEmbed{Kind: EmbedKindIFrame, Provider: provider, URL: url}
+	}
+	image := func(provider string, url string) *MediaEmbed {
+		return &MediaEmbed{Kind: EmbedKindImage, Provider: provider, URL: url}
+	}
+	video := func(provider string, url string) *MediaEmbed {
+		return &MediaEmbed{Kind: EmbedKindVideo, Provider: provider, URL: url}
+	}
+
+	tests := []struct {
+		name      string
+		post      FeedPost
+		wantType  FeedPostType
+		wantEmbed *MediaEmbed
+	}{
+		// Post metadata
+		{"text", FeedPost{PostLink: "/r/foo/comments/abc/title/"}, FeedPostTypeText, nil},
+		{"poll", FeedPost{PostLink: "/r/foo/comments/abc/title/", IsPoll: true}, FeedPostTypePoll, nil},
+		{"link", FeedPost{PostLink: "https://example.com/article"}, FeedPostTypeLink, nil},
+		{"unparsable link", FeedPost{PostLink: "https://example.com/%zz.jpg"}, FeedPostTypeLink, nil},
+
+		// Galleries and Reddit videos win over the extension rules
+		{"gallery", FeedPost{PostLink: "https://www.reddit.com/gallery/abc"}, FeedPostTypeGallery, nil},
+		{"gallery with extension", FeedPost{PostLink: "https://www.reddit.com/gallery/abc.jpg"}, FeedPostTypeGallery, nil},
+		{"reddit video", FeedPost{PostLink: "https://v.redd.it/abc"}, FeedPostTypeVideo, nil},
+		{"reddit video with extension", FeedPost{PostLink: "https://v.redd.it/abc.mp4"}, FeedPostTypeVideo, nil},
+
+		// Imgur
+		{"imgur album", FeedPost{PostLink: "https://imgur.com/a/funny-cats-AbC123"}, FeedPostTypeEmbed,
+			iframe("imgur", "https://imgur.com/a/AbC123/embed?pub=true")},
+		{"imgur gallery", FeedPost{PostLink: "https://imgur.com/gallery/AbC123"}, FeedPostTypeEmbed,
+			iframe("imgur", "https://imgur.com/a/AbC123/embed?pub=true")},
+		{"imgur gifv", FeedPost{PostLink: "https://i.imgur.com/AbC123.gifv"}, FeedPostTypeAnimated,
+			video("imgur", "https://i.imgur.com/AbC123.mp4")},
+		{"imgur page", FeedPost{PostLink: "https://imgur.com/AbC123"}, FeedPostTypeImage,
+			image("imgur", "https://i.imgur.com/AbC123.jpg")},
+		{"imgur image", FeedPost{PostLink: "https://i.imgur.com/AbC123.png"}, FeedPostTypeImage,
+			image("i.imgur.com", "https://i.imgur.com/AbC123.png")},
+		{"imgur bad album ID", FeedPost{PostLink: "https://imgur.com/a/Ab%22C123"}, FeedPostTypeLink, nil},
+
+		// YouTube
+		{"youtube watch", FeedPost{PostLink: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=10"}, FeedPostTypeEmbed,
+			iframe("youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ")},
+		{"youtube mobile", FeedPost{PostLink: "https://m.youtube.com/watch?v=dQw4w9WgXcQ"}, FeedPostTypeEmbed,
+			iframe("youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ")},
+		{"youtube short link", FeedPost{PostLink: "https://youtu.be/dQw4w9WgXcQ"}, FeedPostTypeEmbed,
+			iframe("youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ")},
+		{"youtube shorts", FeedPost{PostLink: "https://www.youtube.com/shorts/dQw4w9WgXcQ"}, FeedPostTypeEmbed,
+			iframe("youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ")},
+		{"youtube ID too short", FeedPost{PostLink: "https://www.youtube.com/watch?v=abc"}, FeedPostTypeLink, nil},
+		{"youtube ID with quote", FeedPost{PostLink: "https://www.youtube.com/watch?v=dQw4w9WgXcQ%22onload"}, FeedPostTypeLink, nil},
+		{"youtube ID with path", FeedPost{PostLink: "https://www.youtube.com/embed/dQw4w9WgXcQ%2F..%2Fx"}, FeedPostTypeLink, nil},
+		{"youtube without ID", FeedPost{PostLink: "https://www.youtube.com/watch"}, FeedPostTypeLink, nil},
+		{"youtube channel", FeedPost{PostLink: "https://www.youtube.com/c/someone"}, FeedPostTypeLink, nil},
+		{"youtube short link path", FeedPost{PostLink: "https://youtu.be/dQw4w9WgXcQ/extra"}, FeedPostTypeLink, nil},
+		{"youtube lookalike", FeedPost{PostLink: "https://notyoutube.com/watch?v=dQw4w9WgXcQ"}, FeedPostTypeLink, nil},
+		{"youtube subdomain lookalike", FeedPost{PostLink: "https://youtube.com.evil.test/watch?v=dQw4w9WgXcQ"}, FeedPostTypeLink, nil},
+
+		// Vimeo
+		{"vimeo", FeedPost{PostLink: "https://vimeo.com/123456"}, FeedPostTypeEmbed,
+			iframe("vimeo", "https://player.vimeo.com/video/123456")},
+		{"vimeo non-numeric ID", FeedPost{PostLink: "https://vimeo.com/abc123"}, FeedPostTypeLink, nil},
+		{"vimeo channel", FeedPost{PostLink: "https://vimeo.com/channels/staff/123456"}, FeedPostTypeLink, nil},
+
+		// Streamable
+		{"streamable", FeedPost{PostLink: "https://streamable.com/abc123"}, FeedPostTypeEmbed,
+			iframe("streamable", "https://streamable.com/e/abc123")},
+		{"streamable ID with dash", FeedPost{PostLink: "https://streamable.com/abc-123"}, FeedPostTypeLink, nil},
+		{"streamable ID with quote", FeedPost{PostLink: "https://streamable.com/abc%22123"}, FeedPostTypeLink, nil},
+
+		// File extensions
+		{"image", FeedPost{PostLink: "https://www.example.com/a.JPG?width=640"}, FeedPostTypeImage,
+			image("example.com", "https://www.example.com/a.JPG?width=640")},
+		{"gif", FeedPost{PostLink: "https://example.com/a.gif"}, FeedPostTypeAnimated,
+			image("example.com", "https://example.com/a.gif")},
+		{"video", FeedPost{PostLink: "https://example.com/a.webm"}, FeedPostTypeEmbed,
+			video("example.com", "https://example.com/a.webm")},
+		{"extension in query", FeedPost{PostLink: "https://example.com/a?file=b.jpg"}, FeedPostTypeLink, nil},
+		{"other scheme", FeedPost{PostLink: "ftp://example.com/a.jpg"}, FeedPostTypeLink, nil},
+		{"javascript", FeedPost{PostLink: "javascript:alert(1)//a.jpg"}, FeedPostTypeLink, nil},
+
+		// i.redd.it images don't need an extension, but GIFs are still animated
+		{"reddit image", FeedPost{PostLink: "https://i.redd.it/abc"}, FeedPostTypeImage,
+			image("i.redd.it", "https://i.redd.it/abc")},
+		{"reddit gif", FeedPost{PostLink: "https://i.redd.it/abc.gif"}, FeedPostTypeAnimated,
+			image("i.redd.it", "https://i.redd.it/abc.gif")},
+
+		// Crossposts keep the media of the original post
+		{"crosspost", FeedPost{PostLink: "https://i.redd.it/abc.png", CrosspostParent: &CrosspostParent{}}, FeedPostTypeCrosspost,
+			image("i.redd.it", "https://i.redd.it/abc.png")},
+		{"crossposted text", FeedPost{PostLink: "/r/foo/comments/abc/title/", CrosspostParent: &CrosspostParent{}}, FeedPostTypeCrosspost, nil},
+		{"crossposted poll", FeedPost{PostLink: "/r/foo/comments/abc/title/", IsPoll: true, CrosspostParent: &CrosspostParent{}}, FeedPostTypePoll, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotType, gotEmbed := resolveMedia(&test.post)
+			if gotType != test.wantType {
+				t.Errorf("resolveMedia(%q) type = %s, want %s", test.post.PostLink, gotType, test.wantType)
+			}
+			if (gotEmbed == nil) != (test.wantEmbed == nil) || gotEmbed != nil && *gotEmbed != *test.wantEmbed {
+				t.Errorf("resolveMedia(%q) embed = %+v, want %+v", test.post.PostLink, gotEmbed, test.wantEmbed)
+			}
+		})
+	}
+}
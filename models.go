@@ -3,12 +3,39 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"time"
 )
 
 type Feed struct {
-	Posts        []FeedPost `json:"posts"`
-	NextPageLink string     `json:"nextPageLink"`
+	Posts        []FeedPost     `json:"posts"`
+	NextPageLink string         `json:"nextPageLink"`
+	PrevPageLink string         `json:"prevPageLink"`
+	NextCursor   *PageCursor    `json:"nextCursor,omitempty"`
+	PrevCursor   *PageCursor    `json:"prevCursor,omitempty"`
+	Page         int            `json:"page"`
+	Consent      *ConsentPrompt `json:"consent,omitempty"`
+	Subreddit    *Subreddit     `json:"subreddit,omitempty"`
+
+	// HiddenSeen is the number of posts removed from this page because the
+	// user has already seen them (see SeenPolicy).
+	HiddenSeen int `json:"hiddenSeen,omitempty"`
+}
+
+// PageCursor identifies a neighboring page of a feed, as described by
+// Reddit's own paging links. Exactly one of After and Before is set.
+type PageCursor struct {
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// ConsentPrompt is set on an otherwise empty Feed when Reddit requires the
+// user to opt in before the subreddit can be shown.
+type ConsentPrompt struct {
+	Kind         ConsentKind `json:"kind"`
+	Subreddit    string      `json:"subreddit"`
+	ContinueLink string      `json:"continueLink"`
 }
 
 type FeedPost struct {
@@ -25,6 +52,149 @@ type FeedPost struct {
 	CommentsLink  string       `json:"commentsLink"`
 	IsSpoiler     bool         `json:"isSpoiler"`
 	IsNSFW        bool         `json:"isNSFW"`
+
+	// Domain is the host the post links to (e.g. "i.redd.it"), or
+	// "self.[subreddit]" for text posts.
+	Domain      string `json:"domain"`
+	LinkFlair   *Flair `json:"linkFlair,omitempty"`
+	AuthorFlair *Flair `json:"authorFlair,omitempty"`
+
+	// Distinguished is "moderator", "admin" or "special" when the OP posted
+	// in an official capacity, and empty otherwise.
+	Distinguished string `json:"distinguished,omitempty"`
+
+	Gildings        int              `json:"gildings"`
+	IsStickied      bool             `json:"isStickied"`
+	IsLocked        bool             `json:"isLocked"`
+	IsArchived      bool             `json:"isArchived"`
+	IsPromoted      bool             `json:"isPromoted"`
+	CrosspostParent *CrosspostParent `json:"crosspostParent,omitempty"`
+
+	// SelfText is the plain text body of a text post, and SelfTextHTML is
+	// the same body as sanitized HTML. Both are empty for other post types,
+	// and for text posts without a body.
+	SelfText     string        `json:"selfText,omitempty"`
+	SelfTextHTML template.HTML `json:"selfTextHTML,omitempty"`
+
+	// IsPoll is set for posts that contain a poll. Old Reddit can't display
+	// polls, so they have to be viewed on Reddit itself.
+	IsPoll bool `json:"isPoll"`
+
+	// Embed describes how to show the post's media inline. It is nil for
+	// posts without (supported) media.
+	Embed *MediaEmbed `json:"embed,omitempty"`
+
+	// Video is set for videos hosted on Reddit (v.redd.it), including
+	// crossposts of them.
+	Video *RedditVideo `json:"video,omitempty"`
+
+	// Images lists the resolutions of the post's image (or its preview)
+	// that Reddit offers, from smallest to largest. It includes the
+	// thumbnail, and is empty for posts without any image.
+	Images []ImageVariant `json:"images,omitempty"`
+
+	// AboveFold is set by renderFeed for the first few posts on a page,
+	// whose images are loaded right away instead of lazily.
+	AboveFold bool `json:"-"`
+
+	// MediaHidden is set when the post's media (including any link to it)
+	// was removed because of the user's preferences (see MediaPolicy).
+	MediaHidden bool `json:"mediaHidden,omitempty"`
+
+	// Seen is set when the user has already seen the post (see SeenStore).
+	Seen bool `json:"seen,omitempty"`
+
+	// Saved is set when the user has saved the post (see SavedStore).
+	Saved bool `json:"saved,omitempty"`
+}
+
+// ImageVariant is one resolution of an image.
+type ImageVariant struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type RedditVideo struct {
+	DASHURL     string `json:"dashURL,omitempty"`
+	HLSURL      string `json:"hlsURL,omitempty"`
+	FallbackURL string `json:"fallbackURL,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Duration    int    `json:"duration,omitempty"`
+	HasAudio    bool   `json:"hasAudio"`
+
+	// Sources lists the available sources from best to worst, as chosen by
+	// the server. Kind is one of the VideoSource* constants.
+	Sources []VideoSource `json:"sources"`
+}
+
+type VideoSource struct {
+	Kind     string `json:"kind"`
+	URL      string `json:"url"`
+	MIMEType string `json:"mimeType"`
+}
+
+// MediaEmbed describes media that can be shown inline. Kind is one of the
+// EmbedKind* constants, and determines how URL should be used (as the source
+// of an <img>, a <video> or an <iframe>).
+type MediaEmbed struct {
+	Kind     string `json:"kind"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+}
+
+type Flair struct {
+	Text            string `json:"text"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+}
+
+// CrosspostParent describes the original post when a FeedPost is a crosspost.
+type CrosspostParent struct {
+	Title     string    `json:"title"`
+	Subreddit string    `json:"subreddit"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Subreddit describes the community a feed belongs to, as shown in old
+// Reddit's side bar. Rules are only populated when they are fetched
+// separately (see RedditParser.FetchSubredditRules).
+type Subreddit struct {
+	Name            string          `json:"name"`
+	Title           string          `json:"title"`
+	Subscribers     int             `json:"subscribers"`
+	ActiveUsers     int             `json:"activeUsers"`
+	DescriptionHTML template.HTML   `json:"descriptionHTML"`
+	Rules           []SubredditRule `json:"rules,omitempty"`
+	Created         time.Time       `json:"created"`
+	IsNSFW          bool            `json:"isNSFW"`
+	Moderators      []string        `json:"moderators"`
+}
+
+type SubredditRule struct {
+	Title           string        `json:"title"`
+	DescriptionHTML template.HTML `json:"descriptionHTML,omitempty"`
+}
+
+// ErrorInfo describes a failed request. It is rendered by the error template,
+// and wrapped in an ErrorResponse for JSON output.
+type ErrorInfo struct {
+	StatusCode int    `json:"status"`
+	Code       string `json:"code"`
+	Title      string `json:"title"`
+	Message    string `json:"message"`
+	RetryLink  string `json:"retryLink,omitempty"`
+
+	// MessageKey identifies Message in the message catalog, so it can be
+	// translated on HTML error pages. MessageArgs are its format arguments.
+	MessageKey  string `json:"-"`
+	MessageArgs []any  `json:"-"`
+}
+
+type ErrorResponse struct {
+	Error ErrorInfo `json:"error"`
 }
 
 // ------------------------------------------------------------------------- //
@@ -39,6 +209,10 @@ const (
 	FeedPostTypeImage
 	FeedPostTypeVideo
 	FeedPostTypeGallery
+	FeedPostTypeAnimated
+	FeedPostTypeEmbed
+	FeedPostTypeCrosspost
+	FeedPostTypePoll
 )
 
 func (f FeedPostType) String() string {
@@ -53,6 +227,14 @@ func (f FeedPostType) String() string {
 		return "video"
 	case FeedPostTypeGallery:
 		return "gallery"
+	case FeedPostTypeAnimated:
+		return "animated"
+	case FeedPostTypeEmbed:
+		return "embed"
+	case FeedPostTypeCrosspost:
+		return "crosspost"
+	case FeedPostTypePoll:
+		return "poll"
 	default:
 		return fmt.Sprintf("FeedPostType(%d)", f)
 	}
@@ -80,6 +262,14 @@ func (f *FeedPostType) UnmarshalJSON(data []byte) error {
 		t = FeedPostTypeVideo
 	case "gallery":
 		t = FeedPostTypeGallery
+	case "animated":
+		t = FeedPostTypeAnimated
+	case "embed":
+		t = FeedPostTypeEmbed
+	case "crosspost":
+		t = FeedPostTypeCrosspost
+	case "poll":
+		t = FeedPostTypePoll
 	default:
 		return fmt.Errorf("%s does not belong to FeedPostType values", s)
 	}
@@ -97,6 +97,15 @@ type feedOpts struct {
 	// precedence if the two conflict with one another.
 	LastPostID *string
 
+	// FirstPostID is used for paging backwards. If non-nil, it should be the
+	// ID of the first feed post on the following page, and the posts
+	// immediately before it will be returned. It is ignored when LastPostID
+	// is set.
+	//
+	// When paging backwards, Count should be one more than the number of
+	// posts that precede FirstPostID.
+	FirstPostID *string
+
 	// Any headers provided in the original HTTP request that should be
 	// forwarded to Reddit.
 	Headers http.Header
@@ -129,6 +138,10 @@ func WithSortMethod(sortMethod SortMethod) FeedOption {
 
 func WithCount(count int) FeedOption {
 	return func(opts *feedOpts) error {
+		if count < 0 {
+			return errors.New("count must not be negative")
+		}
+
 		opts.Count = count
 		return nil
 	}
@@ -141,6 +154,13 @@ func WithLastPostID(lastPostID string) FeedOption {
 	}
 }
 
+func WithFirstPostID(firstPostID string) FeedOption {
+	return func(opts *feedOpts) error {
+		opts.FirstPostID = &firstPostID
+		return nil
+	}
+}
+
 func WithHeaders(headers http.Header) FeedOption {
 	return func(opts *feedOpts) error {
 		opts.Headers = headers
@@ -7,15 +7,39 @@ import (
 	"fmt"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"html/template"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	defaultBaseURL = "http://old.reddit.com"
+
+	// defaultPageSize is the number of posts old Reddit shows per page for
+	// logged out users.
+	defaultPageSize = 25
+)
+
 type RedditParser struct {
 	Client *http.Client
+
+	// ConsentPolicy decides how NSFW and quarantine interstitials are
+	// handled. The zero value shows a local consent page.
+	ConsentPolicy ConsentPolicy
+
+	// FetchMissingSelfText enables downloading the comments page of text
+	// posts whose body isn't embedded in the feed page. This costs one extra
+	// request per affected post.
+	FetchMissingSelfText bool
+
+	// FetchSubredditRules enables downloading a subreddit's rules page
+	// alongside its feed, since old Reddit doesn't show them in the side
+	// bar. This costs one extra request per subreddit feed.
+	FetchSubredditRules bool
 }
 
 // Feed is used to access the front page or an individual subreddit.
@@ -26,12 +50,13 @@ func (rp *RedditParser) Feed(
 
 	// Process user options
 	opts := &feedOpts{
-		BaseURL:    "http://old.reddit.com",
-		Subreddit:  nil,
-		SortMethod: SortMethodDefault,
-		Count:      0,
-		LastPostID: nil,
-		Headers:    nil,
+		BaseURL:     defaultBaseURL,
+		Subreddit:   nil,
+		SortMethod:  SortMethodDefault,
+		Count:       0,
+		LastPostID:  nil,
+		FirstPostID: nil,
+		Headers:     nil,
 	}
 	for _, opt := range options {
 		err := opt(opts)
@@ -39,6 +64,9 @@ func (rp *RedditParser) Feed(
 			return nil, err
 		}
 	}
+	if opts.LastPostID != nil {
+		opts.FirstPostID = nil
+	}
 
 	// Construct the URL
 	getURL := constructURL(opts)
@@ -50,46 +78,230 @@ func (rp *RedditParser) Feed(
 		return nil, err
 	}
 
+	// Reddit serves some pages (e.g. private or NSFW subreddits) as
+	// interstitials with a 200 status code rather than an error.
+	if err := detectInterstitial(doc); err != nil {
+		var consentErr *ConsentError
+		if !errors.As(err, &consentErr) {
+			return nil, err
+		}
+
+		switch rp.ConsentPolicy {
+		case ConsentPolicyAccept:
+			// Opt in, then try again. If Reddit still shows the
+			// interstitial, give up rather than looping.
+			if err := rp.Consent(ctx, consentErr.Kind, options...); err != nil {
+				return nil, fmt.Errorf("failed to submit %s consent: %w", consentErr.Kind, err)
+			}
+			doc, err = rp.getFeedDocument(ctx, getURL, opts.Headers)
+			if err != nil {
+				return nil, err
+			}
+			if err := detectInterstitial(doc); err != nil {
+				return nil, err
+			}
+
+		case ConsentPolicyPrompt:
+			if opts.Subreddit == nil {
+				return nil, err
+			}
+
+			// Let the user decide. The consent page links back to this
+			// feed on localhost.
+			localOpts := *opts
+			localOpts.BaseURL = ""
+			return &Feed{
+				Posts: []FeedPost{},
+				Consent: &ConsentPrompt{
+					Kind:         consentErr.Kind,
+					Subreddit:    *opts.Subreddit,
+					ContinueLink: constructURL(&localOpts),
+				},
+			}, nil
+
+		default:
+			return nil, err
+		}
+	}
+
 	// Parse the feed from the HTML tree
 	posts, err := getFeedPosts(doc)
 	if err != nil {
 		return nil, err
 	}
 
-	// Construct the next page link. Note that we want to direct the user back
-	// to localhost, not to the main Reddit host.
-	opts.BaseURL = ""
-	opts.LastPostID = &posts[len(posts)-1].ID
-	nextPageLink := constructURL(opts)
+	if rp.FetchMissingSelfText {
+		rp.fillMissingSelfText(ctx, posts, opts.Headers)
+	}
+
+	// Reddit's own nav buttons tell us whether there are more pages, and
+	// carry the correct cursors for reaching them. An empty feed (e.g. a new
+	// subreddit, or a page that only contained ads) won't have any.
+	nextCursor, prevCursor := findPageCursors(doc)
+
+	// Translate the cursors into paging links. Note that we want to direct
+	// the user back to localhost, not to the main Reddit host.
+	localOpts := *opts
+	localOpts.BaseURL = ""
+
+	feed := &Feed{
+		Posts:      posts,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
+		Page:       pageStart(opts, nextCursor, prevCursor, len(posts))/defaultPageSize + 1,
+	}
+	if nextCursor != nil {
+		feed.NextPageLink = cursorURL(localOpts, nextCursor)
+	}
+	if prevCursor != nil {
+		feed.PrevPageLink = cursorURL(localOpts, prevCursor)
+	}
+
+	// Subreddit pages describe the community in the side bar. The front page
+	// and multireddits (e.g. "a+b") don't have a single community to show.
+	if opts.Subreddit != nil && !strings.Contains(*opts.Subreddit, "+") {
+		if subreddit, err := findSubreddit(doc); err == nil {
+			if rp.FetchSubredditRules {
+				rp.fillSubredditRules(ctx, subreddit, opts)
+			}
+			feed.Subreddit = subreddit
+		}
+	}
+
+	return feed, nil
+}
+
+// Post fetches a single post from its comments page, where it's laid out the
+// same way as in a feed. 'id' is the post's fullname (e.g. "t3_1a2b3c"). Only
+// the BaseURL and Headers options apply.
+func (rp *RedditParser) Post(
+	ctx context.Context,
+	id string,
+	options ...FeedOption,
+) (*FeedPost, error) {
+
+	opts := &feedOpts{BaseURL: defaultBaseURL}
+	for _, opt := range options {
+		err := opt(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reddit redirects this to the post's full permalink
+	getURL := opts.BaseURL + "/comments/" + strings.TrimPrefix(id, "t3_") + "/"
+	logF(LevelTrace, "Issuing request: GET %s", getURL)
+
+	doc, err := rp.getFeedDocument(ctx, getURL, opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	// NSFW and quarantined posts are gated like their subreddits' feeds.
+	// There's no feed to show a prompt in, so only ConsentPolicyAccept gets
+	// past the gate (or an opt-in the user already gave for the feed).
+	if err := detectInterstitial(doc); err != nil {
+		var consentErr *ConsentError
+		if !errors.As(err, &consentErr) || rp.ConsentPolicy != ConsentPolicyAccept {
+			return nil, err
+		}
+		subreddit, ok := interstitialSubreddit(doc)
+		if !ok {
+			return nil, err
+		}
+
+		consentOptions := []FeedOption{
+			WithBaseURL(opts.BaseURL),
+			WithSubreddit(subreddit),
+			WithHeaders(opts.Headers),
+		}
+		if err := rp.Consent(ctx, consentErr.Kind, consentOptions...); err != nil {
+			return nil, fmt.Errorf("failed to submit %s consent: %w", consentErr.Kind, err)
+		}
+		doc, err = rp.getFeedDocument(ctx, getURL, opts.Headers)
+		if err != nil {
+			return nil, err
+		}
+		if err := detectInterstitial(doc); err != nil {
+			return nil, err
+		}
+	}
 
-	return &Feed{
-		Posts:        posts,
-		NextPageLink: nextPageLink,
-	}, nil
+	// The comments are 'things' too, so look for the post by its ID
+	thing, err := BreadthFirstSearch(doc,
+		HasAttributeWithValue("data-fullname", id),
+		Not(IsTag(atom.Head)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPostNotFound, id)
+	}
+	return tryParseFeedPost(thing)
 }
 
 // ------------------------------------------------------------------------- //
 // Helpers
 // ------------------------------------------------------------------------- //
 
+// pageStart returns the number of posts that precede the current page, which
+// is how Reddit ranks posts and interprets the 'count' parameter:
+//
+//   - Paging forward ("after"), 'count' is the number of posts already seen.
+//   - Paging backward ("before"), 'count' is one more than the number of
+//     posts preceding the *following* page.
+//
+// Reddit's own cursors are preferred when available, since they account for
+// any posts Reddit skipped. Otherwise, we fall back to the request's options.
+func pageStart(opts *feedOpts, next *PageCursor, prev *PageCursor, numPosts int) int {
+	switch {
+	case next != nil:
+		return max(next.Count-numPosts, 0)
+	case prev != nil:
+		return max(prev.Count-1, 0)
+	case opts.FirstPostID != nil:
+		return max(opts.Count-1-numPosts, 0)
+	case opts.LastPostID != nil:
+		return opts.Count
+	default:
+		return 0
+	}
+}
+
+// cursorURL builds the URL for the page 'cursor' points to, keeping the
+// remaining options (subreddit, sort method, etc.) from 'opts'.
+func cursorURL(opts feedOpts, cursor *PageCursor) string {
+	opts.Count = cursor.Count
+	opts.LastPostID = nil
+	opts.FirstPostID = nil
+	if cursor.After != "" {
+		opts.LastPostID = &cursor.After
+	} else if cursor.Before != "" {
+		opts.FirstPostID = &cursor.Before
+	}
+	return constructURL(&opts)
+}
+
+// constructURL builds the URL for the feed described by 'opts'. Local and
+// upstream URLs share the same layout (see feedRouteTable), so the only
+// difference between the two is the BaseURL.
 func constructURL(opts *feedOpts) string {
 
-	getURL := opts.BaseURL
-	if opts.Subreddit != nil {
-		getURL = fmt.Sprintf("%s/r/%s", getURL, *opts.Subreddit)
+	route := feedRoute{
+		Subreddit:  opts.Subreddit,
+		SortMethod: opts.SortMethod,
 	}
+	getURL := opts.BaseURL + route.Path()
+
 	values := url.Values{}
-	if opts.SortMethod != SortMethodDefault {
-		values.Set("sort", opts.SortMethod.URLString())
-	}
 	if opts.Count != 0 {
 		values.Set("count", fmt.Sprintf("%d", opts.Count))
 	}
 	if opts.LastPostID != nil {
 		values.Set("after", *opts.LastPostID)
+	} else if opts.FirstPostID != nil {
+		values.Set("before", *opts.FirstPostID)
 	}
 	if v := values.Encode(); v != "" {
-		getURL = fmt.Sprintf("%s/?%s", getURL, v)
+		getURL = fmt.Sprintf("%s?%s", getURL, v)
 	}
 
 	return getURL
@@ -107,6 +319,10 @@ func (rp *RedditParser) getFeedDocument(
 
 	body, _, err := get(ctx, rp.Client, url, headers)
 	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			return nil, classifyHTTPError(httpErr, url)
+		}
 		return nil, err
 	}
 
@@ -115,14 +331,18 @@ func (rp *RedditParser) getFeedDocument(
 
 func getFeedPosts(doc *html.Node) ([]FeedPost, error) {
 
-	// 1. Find the "siteTable" element
+	// 1. Find the "siteTable" element. Old Reddit omits it entirely for
+	// subreddits that don't exist, showing a "nothing here" message instead.
 	siteTable, err := getSiteTable(doc)
 	if err != nil {
+		if isNoResultsPage(doc) {
+			return nil, ErrSubredditNotFound
+		}
 		return nil, err
 	}
 
 	// 2. Iterate through the site table and extract each feed post that we find
-	var posts []FeedPost
+	posts := []FeedPost{}
 	for c := siteTable.FirstChild; c != nil; c = c.NextSibling {
 		if c.Type != html.ElementNode {
 			continue
@@ -145,22 +365,172 @@ func getSiteTable(n *html.Node) (*html.Node, error) {
 		Not(IsTag(atom.Head)),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("site table not found: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrSiteTableNotFound, err)
 	}
 
 	return siteTable, nil
 }
 
+// detectInterstitial checks whether 'doc' is one of the interstitial pages old
+// Reddit shows in place of a feed, returning the corresponding typed error. It
+// returns nil for regular pages. Interstitials look like:
+//
+//	<div class="interstitial">
+//	  <h3>...</h3>
+//	  <form action="/over18" ...>...</form>
+//	</div>
+func detectInterstitial(doc *html.Node) error {
+
+	interstitial, err := BreadthFirstSearch(doc,
+		And(
+			IsTag(atom.Div),
+			HasClass("interstitial"),
+		),
+		Not(IsTag(atom.Head)),
+	)
+	if err != nil {
+		return nil
+	}
+
+	// The opt-in forms are the most reliable signal for the NSFW and
+	// quarantine gates.
+	hasForm := func(actionRegex string) bool {
+		_, err := BreadthFirstSearch(interstitial,
+			And(
+				IsTag(atom.Form),
+				HasAttributeWithValueRegex("action", actionRegex),
+			),
+			RecurseAlways,
+		)
+		return err == nil
+	}
+	if hasForm("over18") {
+		return &ConsentError{Kind: ConsentKindOver18}
+	}
+	if hasForm("quarantine") {
+		return &ConsentError{Kind: ConsentKindQuarantine}
+	}
+
+	// Otherwise fall back to the explanatory text (private, banned, etc.)
+	if reason := detectSubredditState(TextContent(interstitial)); reason != nil {
+		return reason
+	}
+	return nil
+}
+
+// isNoResultsPage reports whether 'doc' contains old Reddit's "there doesn't
+// seem to be anything here" message.
+//
+// <p id="noresults" class="error">there doesn't seem to be anything here</p>
+func isNoResultsPage(doc *html.Node) bool {
+	_, err := BreadthFirstSearch(doc,
+		HasAttributeWithValue("id", "noresults"),
+		Not(IsTag(atom.Head)),
+	)
+	return err == nil
+}
+
+// findPageCursors extracts the next and previous page cursors from old
+// Reddit's nav buttons. Either (or both) will be nil when the corresponding
+// button isn't present, e.g. on the first or last page.
+//
+//	<div class="nav-buttons">
+//	  <span class="nextprev">view more:
+//	    <span class="prev-button">
+//	      <a href="https://old.reddit.com/r/foo/?count=26&before=t3_abc" rel="nofollow prev">‹ prev</a>
+//	    </span>
+//	    <span class="separator"></span>
+//	    <span class="next-button">
+//	      <a href="https://old.reddit.com/r/foo/?count=50&after=t3_xyz" rel="nofollow next">next ›</a>
+//	    </span>
+//	  </span>
+//	</div>
+func findPageCursors(doc *html.Node) (next *PageCursor, prev *PageCursor) {
+
+	navButtons, err := BreadthFirstSearch(doc,
+		And(
+			IsTag(atom.Div),
+			HasClass("nav-buttons"),
+		),
+		Not(IsTag(atom.Head)),
+	)
+	if err != nil {
+		return nil, nil
+	}
+
+	parseButton := func(buttonClass string) *PageCursor {
+		button, err := BreadthFirstSearch(navButtons,
+			And(
+				IsTag(atom.Span),
+				HasClass(buttonClass),
+			),
+			RecurseAlways,
+		)
+		if err != nil {
+			return nil
+		}
+
+		link, err := BreadthFirstSearch(button,
+			And(
+				IsTag(atom.A),
+				HasAttribute("href"),
+			),
+			RecurseAlways,
+		)
+		if err != nil {
+			return nil
+		}
+
+		href, _ := GetAttribute(link, "href")
+		cursor, err := parsePageCursor(href)
+		if err != nil {
+			return nil
+		}
+		return cursor
+	}
+
+	return parseButton("next-button"), parseButton("prev-button")
+}
+
+func parsePageCursor(href string) (*PageCursor, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	cursor := &PageCursor{
+		After:  query.Get("after"),
+		Before: query.Get("before"),
+	}
+	if cursor.After == "" && cursor.Before == "" {
+		return nil, fmt.Errorf("'%s' is not a paging link", href)
+	}
+	if count, err := strconv.Atoi(query.Get("count")); err == nil {
+		cursor.Count = count
+	}
+
+	return cursor, nil
+}
+
 // ------------------------------------------------------------------------- //
 // FeedPost parser
 // ------------------------------------------------------------------------- //
 
 var (
+	ErrSiteTableNotFound = errors.New("site table not found")
+	ErrPostNotFound      = errors.New("post not found")
 	ErrNotAPost          = errors.New("not a post")
 	ErrPostIsAd          = errors.New("post is an ad")
 	ErrTitleNotFound     = errors.New("title not found")
 	ErrThumbnailNotFound = errors.New("thumbnail not found")
 	ErrCommentsNotFound  = errors.New("comments not found")
+	ErrFlairNotFound     = errors.New("flair not found")
+	ErrExpandoNotFound   = errors.New("expando not found")
+	ErrSelfTextNotFound  = errors.New("self text not found")
+	ErrVideoNotFound     = errors.New("video not found")
+
+	ErrSubredditInfoNotFound = errors.New("subreddit info not found")
 )
 
 func tryParseFeedPost(n *html.Node) (*FeedPost, error) {
@@ -175,16 +545,36 @@ func tryParseFeedPost(n *html.Node) (*FeedPost, error) {
 	var postLink string
 	var isSpoiler bool
 	var isNSFW bool
+	var domain string
+	var gildings int
+	var isStickied bool
+	var isLocked bool
+	var isArchived bool
+	var isPromoted bool
+	var crosspostParent CrosspostParent
 
 	for _, attr := range n.Attr {
 
 		switch attr.Key {
 
-		// Padding elements that can be skipped
+		// Padding elements that can be skipped. Otherwise, some post
+		// properties are only exposed via the element's classes.
 		case "class":
 			if attr.Val == "clearleft" || attr.Val == "nav-buttons" {
 				return nil, ErrNotAPost
 			}
+			for _, c := range strings.Fields(attr.Val) {
+				switch c {
+				case "stickied":
+					isStickied = true
+				case "locked":
+					isLocked = true
+				case "archived":
+					isArchived = true
+				case "promoted":
+					isPromoted = true
+				}
+			}
 
 		// Regular FeedPost fields
 		case "data-fullname":
@@ -225,6 +615,34 @@ func tryParseFeedPost(n *html.Node) (*FeedPost, error) {
 				continue
 			}
 			isNSFW = nsfw
+		case "data-domain":
+			domain = attr.Val
+		case "data-gildings":
+			g, err := strconv.Atoi(attr.Val)
+			if err != nil {
+				continue
+			}
+			gildings = g
+		case "data-promoted":
+			promoted, err := strconv.ParseBool(attr.Val)
+			if err != nil {
+				continue
+			}
+			isPromoted = isPromoted || promoted
+
+		// Crosspost fields (only present on crossposts)
+		case "data-crosspost-root-title":
+			crosspostParent.Title = attr.Val
+		case "data-crosspost-root-subreddit":
+			crosspostParent.Subreddit = attr.Val
+		case "data-crosspost-root-author":
+			crosspostParent.Author = attr.Val
+		case "data-crosspost-root-time":
+			tsMillis, err := strconv.ParseInt(attr.Val, 10, 64)
+			if err != nil {
+				continue
+			}
+			crosspostParent.Timestamp = time.UnixMilli(tsMillis).UTC()
 
 		// Known nodes that can be skipped
 		case "data-adserver-impression-id":
@@ -232,10 +650,20 @@ func tryParseFeedPost(n *html.Node) (*FeedPost, error) {
 		}
 	}
 
+	// Every real post carries its ID. Anything else (e.g. the "nothing here"
+	// message in an empty feed) isn't a post.
+	if id == "" {
+		return nil, ErrNotAPost
+	}
+
 	// Try to find any remaining fields that are child elements
 	title, _ := findTitle(n)
 	thumbnailLink, _ := findThumbnailLink(n)
 	commentsLink, _ := findCommentsLink(n)
+	selfText, selfTextHTML, _ := findSelfText(n)
+	linkFlair, _ := findLinkFlair(n)
+	authorFlair, _ := findAuthorFlair(n)
+	distinguished := findDistinguished(n)
 
 	post := &FeedPost{
 		ID:            id,
@@ -251,8 +679,25 @@ func tryParseFeedPost(n *html.Node) (*FeedPost, error) {
 		CommentsLink:  commentsLink,
 		IsSpoiler:     isSpoiler,
 		IsNSFW:        isNSFW,
+		Domain:        domain,
+		LinkFlair:     linkFlair,
+		AuthorFlair:   authorFlair,
+		Distinguished: distinguished,
+		Gildings:      gildings,
+		IsStickied:    isStickied,
+		IsLocked:      isLocked,
+		IsArchived:    isArchived,
+		IsPromoted:    isPromoted,
+		SelfText:      selfText,
+		SelfTextHTML:  selfTextHTML,
+		IsPoll:        hasPoll(n),
 	}
-	post.Type = classifyFeedPost(post)
+	if crosspostParent != (CrosspostParent{}) {
+		post.CrosspostParent = &crosspostParent
+	}
+	post.Type, post.Embed = resolveMedia(post)
+	post.Video = findRedditVideo(n, post)
+	post.Images = findImageVariants(n, post.Type == FeedPostTypeAnimated || isGIF(post.PostLink))
 	return post, nil
 }
 
@@ -283,6 +728,16 @@ func findTitle(n *html.Node) (string, error) {
 }
 
 func findThumbnailLink(n *html.Node) (string, error) {
+	img, err := findThumbnailImage(n)
+	if err != nil {
+		return "", err
+	}
+
+	src, _ := GetAttribute(img, "src")
+	return "https://" + strings.TrimPrefix(src, "//"), nil
+}
+
+func findThumbnailImage(n *html.Node) (*html.Node, error) {
 
 	// One of the child elements for 'n' is expected to have an <a class="thumbnail ...">
 	// child that represents the visible thumbnail. This element should exist
@@ -296,11 +751,11 @@ func findThumbnailLink(n *html.Node) (string, error) {
 		RecurseAlways,
 	)
 	if err != nil {
-		return "", ErrThumbnailNotFound
+		return nil, ErrThumbnailNotFound
 	}
 
 	// The thumbnail node may or may not have an <img> child tag. If it does,
-	// we'll use that as the thumbnail link. If not, Reddit will render a
+	// we'll use that as the thumbnail. If not, Reddit will render a
 	// placeholder image, and we'll return failure.
 	imgNode, err := BreadthFirstSearch(thumbnailNode,
 		And(
@@ -310,11 +765,9 @@ func findThumbnailLink(n *html.Node) (string, error) {
 		RecurseAlways,
 	)
 	if err != nil {
-		return "", ErrThumbnailNotFound
+		return nil, ErrThumbnailNotFound
 	}
-
-	src, _ := GetAttribute(imgNode, "src")
-	return "https://" + strings.TrimPrefix(src, "//"), nil
+	return imgNode, nil
 }
 
 func findCommentsLink(n *html.Node) (string, error) {
@@ -373,21 +826,236 @@ func findCommentsLink(n *html.Node) (string, error) {
 	return "", ErrCommentsNotFound
 }
 
-func classifyFeedPost(post *FeedPost) FeedPostType {
-	if strings.HasPrefix(post.PostLink, "/r/") {
-		return FeedPostTypeText
+// findExpando returns the contents of a post's expando, which holds the body of
+// text posts and the player for media posts. Feed pages don't render the
+// expando. Instead, its contents are stored as escaped HTML in the
+// 'data-cachedhtml' attribute and inserted when the user expands the post. On
+// comments pages, the expando is already populated.
+//
+//	<div class="expando expando-uninitialized" data-cachedhtml="[ESCAPED HTML]">
+//	</div>
+func findExpando(n *html.Node) (*html.Node, error) {
+
+	expando, err := BreadthFirstSearch(n,
+		And(
+			IsTag(atom.Div),
+			HasClass("expando"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrExpandoNotFound
+	}
+
+	if cached, ok := GetAttribute(expando, "data-cachedhtml"); ok {
+		return ParseFragment(cached)
+	}
+	return expando, nil
+}
+
+func findSelfText(n *html.Node) (string, template.HTML, error) {
+
+	// <div class="expando">
+	//   <form class="usertext">
+	//     <div class="usertext-body md-container">
+	//       <div class="md">[BODY]</div>
+	//     </div>
+	//   </form>
+	// </div>
+	expando, err := findExpando(n)
+	if err != nil {
+		return "", "", ErrSelfTextNotFound
+	}
+
+	body, err := BreadthFirstSearch(expando,
+		And(
+			IsTag(atom.Div),
+			HasClass("md"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return "", "", ErrSelfTextNotFound
+	}
+
+	text := plainText(body)
+	if text == "" {
+		return "", "", ErrSelfTextNotFound
+	}
+	return text, sanitizeHTML(body), nil
+}
+
+// fillMissingSelfText downloads the comments page for any text post whose body
+// wasn't included in the feed page. Failures are logged and otherwise ignored,
+// since the post itself is still usable without its body.
+func (rp *RedditParser) fillMissingSelfText(
+	ctx context.Context,
+	posts []FeedPost,
+	headers http.Header,
+) {
+	for i := range posts {
+		post := &posts[i]
+		if post.Type != FeedPostTypeText || post.SelfTextHTML != "" ||
+			post.CommentsLink == "" {
+			continue
+		}
+
+		doc, err := rp.getFeedDocument(ctx, post.CommentsLink, headers)
+		if err != nil {
+			logF(LevelWarning, "Failed to fetch self text for %s: %v", post.ID, err)
+			continue
+		}
+
+		// The post is the 'thing' with the matching ID
+		thing, err := BreadthFirstSearch(doc,
+			HasAttributeWithValue("data-fullname", post.ID),
+			Not(IsTag(atom.Head)),
+		)
+		if err != nil {
+			continue
+		}
+
+		post.SelfText, post.SelfTextHTML, _ = findSelfText(thing)
+	}
+}
+
+// hasPoll reports whether the post contains a poll. Old Reddit marks these
+// with a "poll" class on the post or on an element inside its expando.
+func hasPoll(n *html.Node) bool {
+	if HasClass("poll")(n) {
+		return true
 	}
-	if strings.HasSuffix(post.PostLink, ".jpg") ||
-		strings.HasSuffix(post.PostLink, ".png") {
-		return FeedPostTypeImage
+
+	expando, err := findExpando(n)
+	if err != nil {
+		return false
 	}
-	if strings.HasPrefix(post.PostLink, "https://www.reddit.com/gallery/") {
-		return FeedPostTypeGallery
+
+	_, err = BreadthFirstSearch(expando,
+		HasAttributeWithValueRegex("class", `(^|\s)poll(-[\w-]+)?(\s|$)`),
+		RecurseAlways,
+	)
+	return err == nil
+}
+
+func findLinkFlair(n *html.Node) (*Flair, error) {
+
+	// <p class="title">
+	//   <a class="title ...">[TITLE]</a>
+	//   <span class="linkflairlabel has-bg" title="[TEXT]"
+	//         style="background-color: #ea0027; color: #ffffff">[TEXT]</span>
+	//   ...
+	// </p>
+	flairNode, err := BreadthFirstSearch(n,
+		And(
+			IsTag(atom.Span),
+			HasClass("linkflairlabel"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrFlairNotFound
 	}
-	if strings.HasPrefix(post.PostLink, "https://v.reddit.com/") ||
-		strings.HasPrefix(post.PostLink, "https://v.redd.it/") {
-		return FeedPostTypeVideo
+
+	return parseFlair(flairNode)
+}
+
+func findAuthorFlair(n *html.Node) (*Flair, error) {
+
+	// <p class="tagline">
+	//   submitted ... by
+	//   <a class="author ...">[OP]</a>
+	//   <span class="flair flair-[CSS CLASS]" title="[TEXT]">[TEXT]</span>
+	//   ...
+	// </p>
+	tagline, err := BreadthFirstSearch(n,
+		And(
+			IsTag(atom.P),
+			HasClass("tagline"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrFlairNotFound
 	}
 
-	return FeedPostTypeLink
+	flairNode, err := BreadthFirstSearch(tagline,
+		And(
+			IsTag(atom.Span),
+			HasClass("flair"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrFlairNotFound
+	}
+
+	return parseFlair(flairNode)
+}
+
+// cssColorRegex restricts flair colors to hex values, since they are copied
+// into the rendered page.
+var cssColorRegex = regexp.MustCompile(`^#[0-9A-Fa-f]{3,8}$`)
+
+func parseFlair(n *html.Node) (*Flair, error) {
+
+	// Prefer the 'title' attribute, which holds the full text even when
+	// emoji are rendered as child elements.
+	text, _ := GetAttribute(n, "title")
+	if text == "" {
+		text = strings.TrimSpace(TextContent(n))
+	}
+	if text == "" {
+		return nil, ErrFlairNotFound
+	}
+	flair := &Flair{
+		Text: text,
+	}
+
+	// e.g. style="background-color: #ea0027; color: #ffffff"
+	style, _ := GetAttribute(n, "style")
+	for _, declaration := range strings.Split(style, ";") {
+		property, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !cssColorRegex.MatchString(value) {
+			continue
+		}
+
+		switch strings.TrimSpace(property) {
+		case "background-color":
+			flair.BackgroundColor = value
+		case "color":
+			flair.TextColor = value
+		}
+	}
+
+	return flair, nil
+}
+
+// findDistinguished returns "moderator", "admin" or "special" if the OP
+// posted in an official capacity, or an empty string otherwise.
+//
+//	<a class="author may-blank moderator">[OP]</a>
+func findDistinguished(n *html.Node) string {
+
+	authorNode, err := BreadthFirstSearch(n,
+		And(
+			IsTag(atom.A),
+			HasClass("author"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return ""
+	}
+
+	for _, d := range []string{"admin", "moderator", "special"} {
+		if HasClass(d)(authorNode) {
+			return d
+		}
+	}
+	return ""
 }
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTheme is the look of feed.css on its own. Every other theme is a
+	// stylesheet in "static/themes/" that's loaded on top of it.
+	defaultTheme = "dark"
+
+	preferenceCookieMaxAge = 365 * 24 * time.Hour
+)
+
+var preferenceNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Preferences are the per-user display settings. Each one can be chosen for a
+// single request with a query parameter (e.g. "?theme=light"), which is also
+// remembered in a cookie of the same name for subsequent requests.
+type Preferences struct {
+	Theme  string
+	Layout FeedLayout
+	Locale string
+
+	// NSFW and Spoilers decide how the media of NSFW and spoiler posts is
+	// shown (see MediaPolicyFor).
+	NSFW     MediaPolicy
+	Spoilers MediaPolicy
+
+	// Seen decides what happens to posts the user has already seen. It only
+	// applies when read tracking is enabled (see SeenStore).
+	Seen SeenPolicy
+}
+
+// readPreferences determines the preferences for 'r'. Query parameters take
+// precedence over cookies, and invalid values are ignored in favor of the
+// defaults. The available themes and locales come from 'registry'. Without an
+// explicit choice, the locale is negotiated from the Accept-Language header.
+func readPreferences(r *http.Request, registry *TemplateRegistry) Preferences {
+	prefs := Preferences{
+		Theme:  defaultTheme,
+		Layout: FeedLayoutCard,
+		Locale: defaultLocale,
+
+		NSFW:     MediaPolicyBlur,
+		Spoilers: MediaPolicyBlur,
+
+		Seen: SeenPolicyMark,
+	}
+
+	if theme, ok := preferenceValue(r, "theme"); ok && slices.Contains(registry.Themes(), theme) {
+		prefs.Theme = theme
+	}
+	if value, ok := preferenceValue(r, "layout"); ok {
+		if layout, err := FeedLayoutFromString(value); err == nil {
+			prefs.Layout = layout
+		}
+	}
+	if locale, ok := preferenceValue(r, "locale"); ok && slices.Contains(registry.Locales(), locale) {
+		prefs.Locale = locale
+	} else if locale, ok := registry.messages.Negotiate(r.Header.Get("Accept-Language")); ok {
+		prefs.Locale = locale
+	}
+	if value, ok := preferenceValue(r, "nsfw"); ok {
+		if policy, err := MediaPolicyFromString(value); err == nil {
+			prefs.NSFW = policy
+		}
+	}
+	if value, ok := preferenceValue(r, "spoilers"); ok {
+		if policy, err := MediaPolicyFromString(value); err == nil {
+			prefs.Spoilers = policy
+		}
+	}
+	if value, ok := preferenceValue(r, "seen"); ok {
+		if policy, err := SeenPolicyFromString(value); err == nil {
+			prefs.Seen = policy
+		}
+	}
+
+	return prefs
+}
+
+// savePreferences remembers any preferences that were chosen with query
+// parameters on 'r' by setting the corresponding cookies.
+func savePreferences(w http.ResponseWriter, r *http.Request, prefs Preferences) {
+	query := r.URL.Query()
+
+	save := func(name string, value string) {
+		if !query.Has(name) {
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     "/",
+			MaxAge:   int(preferenceCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	save("theme", prefs.Theme)
+	save("layout", prefs.Layout.String())
+	save("locale", prefs.Locale)
+	save("nsfw", prefs.NSFW.String())
+	save("spoilers", prefs.Spoilers.String())
+	save("seen", prefs.Seen.String())
+}
+
+// preferenceValue returns the raw value of the preference called 'name' from
+// the query string or, failing that, from its cookie.
+func preferenceValue(r *http.Request, name string) (string, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return "", false
+		}
+		value = cookie.Value
+	}
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !preferenceNameRegex.MatchString(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// findThemes lists the themes available in 'fsys': the default theme, plus one
+// for each stylesheet in "static/themes/".
+func findThemes(fsys fs.FS) []string {
+	themes := []string{defaultTheme}
+
+	paths, err := fs.Glob(fsys, "static/themes/*.css")
+	if err != nil {
+		return themes
+	}
+	for _, p := range paths {
+		name := strings.TrimSuffix(path.Base(p), ".css")
+		if preferenceNameRegex.MatchString(name) && !slices.Contains(themes, name) {
+			themes = append(themes, name)
+		}
+	}
+	return themes
+}
+
+// ------------------------------------------------------------------------- //
+// Layouts
+// ------------------------------------------------------------------------- //
+
+// FeedLayout determines how the posts of a feed are presented. Each layout is
+// a separate page template (see TemplateName).
+type FeedLayout int
+
+const (
+	// FeedLayoutCard shows each post as a large card with its media inline.
+	FeedLayoutCard FeedLayout = iota
+
+	// FeedLayoutCompact shows one post per row: a small thumbnail, the title
+	// and a single line of metadata.
+	FeedLayoutCompact
+
+	// FeedLayoutClassic mimics old Reddit's listing, with the score in a
+	// column next to the thumbnail.
+	FeedLayoutClassic
+
+	// FeedLayoutGallery arranges posts in a grid of images, which suits
+	// image heavy subreddits.
+	FeedLayoutGallery
+)
+
+func (fl FeedLayout) String() string {
+	switch fl {
+	case FeedLayoutCard:
+		return "card"
+	case FeedLayoutCompact:
+		return "compact"
+	case FeedLayoutClassic:
+		return "classic"
+	case FeedLayoutGallery:
+		return "gallery"
+	default:
+		return fmt.Sprintf("FeedLayout(%d)", fl)
+	}
+}
+
+func FeedLayoutFromString(s string) (FeedLayout, error) {
+	switch s {
+	case "card":
+		return FeedLayoutCard, nil
+	case "compact":
+		return FeedLayoutCompact, nil
+	case "classic":
+		return FeedLayoutClassic, nil
+	case "gallery":
+		return FeedLayoutGallery, nil
+	default:
+		return FeedLayout(-1), fmt.Errorf("'%s' is not a feed layout", s)
+	}
+}
+
+// TemplateName returns the page template that renders this layout.
+func (fl FeedLayout) TemplateName() string {
+	switch fl {
+	case FeedLayoutCompact:
+		return "feed_compact.html"
+	case FeedLayoutClassic:
+		return "feed_classic.html"
+	case FeedLayoutGallery:
+		return "feed_gallery.html"
+	default:
+		return "feed.html"
+	}
+}
+
+// ------------------------------------------------------------------------- //
+// Media policies
+// ------------------------------------------------------------------------- //
+
+// MediaPolicy determines how the media (images, videos, thumbnails, etc.) of
+// sensitive posts is shown. Policies are ordered from least to most strict.
+type MediaPolicy int
+
+const (
+	// MediaPolicyShow shows the media like that of any other post.
+	MediaPolicyShow MediaPolicy = iota
+
+	// MediaPolicyBlur blurs the media until it's clicked.
+	MediaPolicyBlur
+
+	// MediaPolicyHide leaves the media out entirely. The server removes it
+	// from the feed, so it isn't sent to the client at all.
+	MediaPolicyHide
+)
+
+func (mp MediaPolicy) String() string {
+	switch mp {
+	case MediaPolicyShow:
+		return "show"
+	case MediaPolicyBlur:
+		return "blur"
+	case MediaPolicyHide:
+		return "hide"
+	default:
+		return fmt.Sprintf("MediaPolicy(%d)", mp)
+	}
+}
+
+func MediaPolicyFromString(s string) (MediaPolicy, error) {
+	switch s {
+	case "show":
+		return MediaPolicyShow, nil
+	case "blur":
+		return MediaPolicyBlur, nil
+	case "hide":
+		return MediaPolicyHide, nil
+	default:
+		return MediaPolicy(-1), fmt.Errorf("'%s' is not a media policy", s)
+	}
+}
+
+// MediaPolicyFor returns the policy for the media of 'post'. Posts that are
+// both NSFW and spoilers get the stricter of the two policies.
+func (p Preferences) MediaPolicyFor(post FeedPost) MediaPolicy {
+	policy := MediaPolicyShow
+	if post.IsNSFW {
+		policy = max(policy, p.NSFW)
+	}
+	if post.IsSpoiler {
+		policy = max(policy, p.Spoilers)
+	}
+	return policy
+}
+
+// Blurs reports whether the media of 'post' should be blurred. It's used by
+// the feed templates.
+func (p Preferences) Blurs(post FeedPost) bool {
+	return p.MediaPolicyFor(post) == MediaPolicyBlur && hasMedia(post)
+}
+
+// applyMediaPolicies removes the media of every post in 'feed' whose policy
+// is MediaPolicyHide, marking those posts with MediaHidden. Their links point
+// to the comments instead, so the media doesn't reach the client at all.
+func applyMediaPolicies(feed *Feed, prefs Preferences) {
+	for i := range feed.Posts {
+		post := &feed.Posts[i]
+		if prefs.MediaPolicyFor(*post) != MediaPolicyHide || !hasMedia(*post) {
+			continue
+		}
+
+		post.ThumbnailLink = ""
+		post.Images = nil
+		post.Embed = nil
+		post.Video = nil
+		post.MediaHidden = true
+
+		// The post's link may well be the media itself (e.g. an i.redd.it
+		// image, a gallery, or a crosspost of either), so link to the
+		// comments instead.
+		post.PostLink = post.CommentsLink
+	}
+}
+
+// hasMedia reports whether 'post' has anything a media policy applies to.
+// The bodies of text posts are already collapsed, so they're left alone.
+func hasMedia(post FeedPost) bool {
+	return post.ThumbnailLink != "" || len(post.Images) > 0 || post.Embed != nil || post.Video != nil
+}
+
+// ------------------------------------------------------------------------- //
+// Seen posts
+// ------------------------------------------------------------------------- //
+
+// SeenPolicy determines what happens to the posts the user has already seen.
+type SeenPolicy int
+
+const (
+	// SeenPolicyMark shows seen posts, but marks them as seen.
+	SeenPolicyMark SeenPolicy = iota
+
+	// SeenPolicyHide removes seen posts from feeds. The server removes them,
+	// so they're left out of JSON feeds as well.
+	SeenPolicyHide
+)
+
+func (sp SeenPolicy) String() string {
+	switch sp {
+	case SeenPolicyMark:
+		return "mark"
+	case SeenPolicyHide:
+		return "hide"
+	default:
+		return fmt.Sprintf("SeenPolicy(%d)", sp)
+	}
+}
+
+func SeenPolicyFromString(s string) (SeenPolicy, error) {
+	switch s {
+	case "mark":
+		return SeenPolicyMark, nil
+	case "hide":
+		return SeenPolicyHide, nil
+	default:
+		return SeenPolicy(-1), fmt.Errorf("'%s' is not a seen posts policy", s)
+	}
+}
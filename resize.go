@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSourceImageBytes and maxSourceImagePixels bound the images we're
+	// willing to download and decode, since decoding allocates memory in
+	// proportion to the image's dimensions.
+	maxSourceImageBytes  = 32 << 20
+	maxSourceImagePixels = 50_000_000
+
+	// imageDownloadTimeout bounds downloading an image to resize, since the
+	// HTTP client doesn't have a timeout of its own.
+	imageDownloadTimeout = 30 * time.Second
+
+	resizedImageQuality = 85
+	resizedImageMaxAge  = 7 * 24 * time.Hour
+
+	// defaultImageCacheBytes is the size the resize cache is trimmed to,
+	// unless ImageResizer.MaxCacheBytes says otherwise. The cache is checked
+	// at most once per imageCachePruneInterval.
+	defaultImageCacheBytes  = 512 << 20
+	imageCachePruneInterval = time.Minute
+)
+
+// resizeWidths are the widths the resizer produces. Only these are accepted,
+// so clients can't fill the cache with arbitrary sizes.
+var resizeWidths = []int{320, 640, 960, 1280}
+
+// defaultResizeHosts are the image hosts the resizer will download from.
+var defaultResizeHosts = []string{
+	"i.redd.it",
+	"preview.redd.it",
+	"external-preview.redd.it",
+	"redditmedia.com",
+	"i.imgur.com",
+}
+
+var (
+	ErrResizeURLNotAllowed = errors.New("image URL not allowed")
+	ErrResizeWidthInvalid  = errors.New("unsupported image width")
+	ErrImageTooLarge       = errors.New("image too large")
+)
+
+// ImageResizer serves downscaled copies of post images, so that small
+// screens don't have to download full resolution images that Reddit only
+// offers in one or two sizes. Resized images are cached on disk.
+//
+//	GET [root]/img?url=[image_url]&w=[width]
+//
+// Images are decoded with the standard library, so JPEG, PNG and GIF (first
+// frame only) are supported. Anything else is redirected to the original.
+type ImageResizer struct {
+	Client *http.Client
+
+	// CacheDir holds the resized images, one file per URL and width.
+	CacheDir string
+
+	// MaxCacheBytes is the most the images in CacheDir may take up. Once it's
+	// exceeded, the least recently used images are deleted. Zero means
+	// defaultImageCacheBytes, and a negative value means no limit.
+	MaxCacheBytes int64
+
+	// AllowedHosts lists the hosts (including their subdomains) images may be
+	// downloaded from. A nil slice means defaultResizeHosts.
+	AllowedHosts []string
+
+	pruneMu   sync.Mutex
+	lastPrune time.Time
+}
+
+// URL returns the local URL of 'src' resized to 'width'.
+func (ir *ImageResizer) URL(src string, width int) string {
+	query := url.Values{}
+	query.Set("url", src)
+	query.Set("w", strconv.Itoa(width))
+	return "/img?" + query.Encode()
+}
+
+// Variants returns resized versions of a post's image, for each of the
+// resizeWidths. 'images' are the variants Reddit offers, and 'original' is the
+// full size image if there is one (e.g. the i.redd.it link of an image post).
+//
+// The original is resized when possible, since it's the best source. Without
+// one, the largest variant is resized instead, and is kept as the largest
+// variant itself. Images from hosts that aren't allowed are returned as-is.
+func (ir *ImageResizer) Variants(images []ImageVariant, original string) []ImageVariant {
+
+	// The largest variant's dimensions give the aspect ratio
+	largest := largestImage(images)
+	if largest.Width <= 0 {
+		return images
+	}
+
+	source, sourceWidth := largest.URL, largest.Width
+	if u, err := url.Parse(original); err == nil && original != "" && ir.allowed(u) {
+		// The original's width isn't known, but it's never smaller than the
+		// previews. Widths beyond it are served at its own size.
+		source, sourceWidth = original, 0
+	}
+	u, err := url.Parse(source)
+	if err != nil || !ir.allowed(u) {
+		return images
+	}
+
+	var variants []ImageVariant
+	for _, width := range resizeWidths {
+		if sourceWidth > 0 && width >= sourceWidth {
+			break
+		}
+		variants = append(variants, ImageVariant{
+			URL:    ir.URL(source, width),
+			Width:  width,
+			Height: largest.Height * width / largest.Width,
+		})
+	}
+	if sourceWidth > 0 {
+		variants = append(variants, largest)
+	}
+	return variants
+}
+
+// ResizeFeed replaces the image variants of every post in 'feed' with resized
+// ones (see Variants). Animated images are left alone, since only their first
+// frame would survive resizing.
+func (ir *ImageResizer) ResizeFeed(feed *Feed) {
+	for i := range feed.Posts {
+		post := &feed.Posts[i]
+
+		original := ""
+		if post.Embed != nil && post.Embed.Kind == EmbedKindImage {
+			original = post.Embed.URL
+		}
+		if post.Type == FeedPostTypeAnimated || isGIF(original) || isGIF(post.PostLink) {
+			continue
+		}
+		post.Images = ir.Variants(post.Images, original)
+	}
+}
+
+func (ir *ImageResizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	src := r.URL.Query().Get("url")
+	width, err := strconv.Atoi(r.URL.Query().Get("w"))
+	if err != nil || !slices.Contains(resizeWidths, width) {
+		http.Error(w, ErrResizeWidthInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(src)
+	if err != nil || !ir.allowed(u) {
+		http.Error(w, ErrResizeURLNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+
+	out, err := ir.resized(r.Context(), u.String(), width)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		logF(LevelWarning, "Failed to resize %s: %v", src, err)
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(out))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(resizedImageMaxAge.Seconds())))
+	_, _ = w.Write(out)
+}
+
+func (ir *ImageResizer) allowed(u *url.URL) bool {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	hosts := ir.AllowedHosts
+	if hosts == nil {
+		hosts = defaultResizeHosts
+	}
+	return slices.ContainsFunc(hosts, func(host string) bool {
+		return hostIs(u, host)
+	})
+}
+
+// resized returns the encoded image at 'src' resized to 'width', from the
+// cache if possible.
+func (ir *ImageResizer) resized(ctx context.Context, src string, width int) ([]byte, error) {
+
+	key := sha256.Sum256([]byte(strconv.Itoa(width) + " " + src))
+	name := hex.EncodeToString(key[:])
+	path := filepath.Join(ir.CacheDir, name[:2], name)
+
+	if out, err := os.ReadFile(path); err == nil {
+		// The modification time tracks when the image was last used, which
+		// decides what's deleted first when the cache is full
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		return out, nil
+	}
+
+	original, err := ir.download(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	out, err := resizeImage(original, width)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first, so concurrent requests never see a
+	// partially written image
+	if err := writeFileAtomic(path, out); err != nil {
+		logF(LevelWarning, "Failed to cache resized image: %v", err)
+	} else {
+		ir.pruneCache()
+	}
+	return out, nil
+}
+
+// pruneCache deletes the least recently used images once the cache has grown
+// beyond MaxCacheBytes. It does nothing if the cache was checked recently.
+func (ir *ImageResizer) pruneCache() {
+
+	limit := ir.MaxCacheBytes
+	if limit == 0 {
+		limit = defaultImageCacheBytes
+	}
+	if limit < 0 {
+		return
+	}
+
+	ir.pruneMu.Lock()
+	defer ir.pruneMu.Unlock()
+	if time.Since(ir.lastPrune) < imageCachePruneInterval {
+		return
+	}
+	ir.lastPrune = time.Now()
+
+	type cachedImage struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var images []cachedImage
+	var total int64
+	err := filepath.WalkDir(ir.CacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // deleted in the meantime
+		}
+		images = append(images, cachedImage{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		logF(LevelWarning, "Failed to scan image cache: %v", err)
+		return
+	}
+	if total <= limit {
+		return
+	}
+
+	slices.SortFunc(images, func(a, b cachedImage) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	removed := 0
+	for _, cached := range images {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(cached.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logF(LevelWarning, "Failed to delete cached image: %v", err)
+			continue
+		}
+		total -= cached.size
+		removed++
+	}
+	logF(LevelDebug, "Deleted %d images from the image cache", removed)
+}
+
+func (ir *ImageResizer) download(ctx context.Context, src string) ([]byte, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, imageDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ir.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceImageBytes {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// resizeImage decodes 'data' and scales it down to 'width' pixels wide,
+// keeping its aspect ratio. PNG and GIF images are encoded as PNG (to keep any
+// transparency), and everything else as JPEG. Images that are already small
+// enough are returned unchanged.
+func resizeImage(data []byte, width int) ([]byte, error) {
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxSourceImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	if config.Width <= width {
+		return data, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	height := max(1, config.Height*width/config.Width)
+	dst := boxDownscale(src, width, height)
+
+	var out bytes.Buffer
+	if format == "png" || format == "gif" {
+		err = png.Encode(&out, dst)
+	} else {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: resizedImageQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// boxDownscale shrinks 'src' to 'width' x 'height' by averaging the block of
+// source pixels that falls under each destination pixel. It's only meant for
+// shrinking, where it looks much better than nearest neighbour sampling.
+func boxDownscale(src image.Image, width int, height int) *image.RGBA {
+
+	// Work on RGBA pixels directly, since image.Image.At is slow
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
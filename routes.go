@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrRouteNotFound = errors.New("no feed route matches the path")
+)
+
+// feedRouteTable lists every feed path we understand, in canonical form. The
+// same table is used to build upstream (old.reddit.com) URLs and local URLs,
+// since the proxy mirrors Reddit's URL scheme. Segments in braces are
+// placeholders:
+//
+//   - {subreddit} is a subreddit name, or several joined by '+'
+//   - {sort} is the URL form of a SortMethod (e.g. "top")
+var feedRouteTable = []string{
+	"/",
+	"/{sort}/",
+	"/r/{subreddit}/",
+	"/r/{subreddit}/{sort}/",
+}
+
+var subredditNameRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+$`)
+
+// feedRoute identifies a feed independently of paging. It is the parsed form
+// of one of the paths in feedRouteTable.
+type feedRoute struct {
+	Subreddit  *string
+	SortMethod SortMethod
+}
+
+// Path returns the canonical path for the route: lower case, with a trailing
+// slash.
+func (fr feedRoute) Path() string {
+
+	hasSubreddit := fr.Subreddit != nil
+	hasSort := fr.SortMethod != SortMethodDefault
+
+	for _, pattern := range feedRouteTable {
+		if strings.Contains(pattern, "{subreddit}") != hasSubreddit ||
+			strings.Contains(pattern, "{sort}") != hasSort {
+			continue
+		}
+
+		path := pattern
+		if hasSubreddit {
+			path = strings.Replace(path, "{subreddit}", strings.ToLower(*fr.Subreddit), 1)
+		}
+		if hasSort {
+			path = strings.Replace(path, "{sort}", fr.SortMethod.URLString(), 1)
+		}
+		return path
+	}
+
+	// Every combination is covered by the table
+	panic("feedRouteTable is incomplete")
+}
+
+// matchFeedRoute parses 'path' using feedRouteTable. Matching is case
+// insensitive and ignores a missing (or repeated) trailing slash, so that
+// non-canonical paths can be redirected to their canonical form.
+func matchFeedRoute(path string) (feedRoute, error) {
+
+	segments := splitPath(path)
+	for _, pattern := range feedRouteTable {
+		patternSegments := splitPath(pattern)
+		if len(patternSegments) != len(segments) {
+			continue
+		}
+
+		route := feedRoute{}
+		matched := true
+		for i, p := range patternSegments {
+			s := segments[i]
+
+			switch p {
+			case "{subreddit}":
+				if !subredditNameRegex.MatchString(s) {
+					matched = false
+				}
+				route.Subreddit = &s
+			case "{sort}":
+				sm, err := SortMethodFromString(strings.ToLower(s))
+				if err != nil {
+					matched = false
+				}
+				route.SortMethod = sm
+			default:
+				if !strings.EqualFold(p, s) {
+					matched = false
+				}
+			}
+			if !matched {
+				break
+			}
+		}
+
+		if matched {
+			return route, nil
+		}
+	}
+
+	return feedRoute{}, ErrRouteNotFound
+}
+
+// splitPath returns the non-empty segments of 'path'. For example,
+// "/r/foobar/hot/" is split into ["r", "foobar", "hot"].
+func splitPath(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// allFeedRoutes returns a route for every combination of subreddit and sort
+// method, which between them use every pattern in feedRouteTable.
+func allFeedRoutes() []feedRoute {
+	subreddit := "foobar"
+	multireddit := "foo+bar"
+
+	var routes []feedRoute
+	for _, sub := range []*string{nil, &subreddit, &multireddit} {
+		for sm := SortMethodDefault; sm <= SortMethodGilded; sm++ {
+			routes = append(routes, feedRoute{Subreddit: sub, SortMethod: sm})
+		}
+	}
+	return routes
+}
+
+func routeString(fr feedRoute) string {
+	if fr.Subreddit == nil {
+		return "front page/" + fr.SortMethod.URLString()
+	}
+	return *fr.Subreddit + "/" + fr.SortMethod.URLString()
+}
+
+func sameRoute(a feedRoute, b feedRoute) bool {
+	if (a.Subreddit == nil) != (b.Subreddit == nil) {
+		return false
+	}
+	if a.Subreddit != nil && !strings.EqualFold(*a.Subreddit, *b.Subreddit) {
+		return false
+	}
+	return a.SortMethod == b.SortMethod
+}
+
+func TestFeedRoutePathMatchesItself(t *testing.T) {
+	for _, route := range allFeedRoutes() {
+		t.Run(routeString(route), func(t *testing.T) {
+			path := route.Path()
+			if !strings.HasSuffix(path, "/") || path != strings.ToLower(path) {
+				t.Errorf("Path() = %q, want a lower case path with a trailing slash", path)
+			}
+
+			matched, err := matchFeedRoute(path)
+			if err != nil {
+				t.Fatalf("matchFeedRoute(%q) failed: %v", path, err)
+			}
+			if !sameRoute(matched, route) {
+				t.Errorf("matchFeedRoute(%q) = %s, want %s", path, routeString(matched), routeString(route))
+			}
+		})
+	}
+}
+
+func TestFeedRouteRoundTrip(t *testing.T) {
+	pages := []struct {
+		name string
+		opts func(opts *feedOpts)
+	}{
+		{"first page", func(opts *feedOpts) {}},
+		{"next page", func(opts *feedOpts) {
+			after := "t3_abc"
+			opts.LastPostID = &after
+			opts.Count = 25
+		}},
+		{"previous page", func(opts *feedOpts) {
+			before := "t3_xyz"
+			opts.FirstPostID = &before
+			opts.Count = 26
+		}},
+	}
+
+	for _, route := range allFeedRoutes() {
+		for _, page := range pages {
+			t.Run(routeString(route)+"/"+page.name, func(t *testing.T) {
+
+				// local -> upstream
+				local := route.Path()
+				matched, err := matchFeedRoute(local)
+				if err != nil {
+					t.Fatalf("matchFeedRoute(%q) failed: %v", local, err)
+				}
+				opts := feedOpts{
+					BaseURL:    defaultBaseURL,
+					Subreddit:  matched.Subreddit,
+					SortMethod: matched.SortMethod,
+				}
+				page.opts(&opts)
+				upstream := constructURL(&opts)
+				if !strings.HasPrefix(upstream, defaultBaseURL+local) {
+					t.Errorf("upstream URL %q doesn't mirror the local path %q", upstream, local)
+				}
+
+				// upstream -> local
+				u, err := url.Parse(upstream)
+				if err != nil {
+					t.Fatalf("invalid upstream URL %q: %v", upstream, err)
+				}
+				back, err := matchFeedRoute(u.Path)
+				if err != nil {
+					t.Fatalf("matchFeedRoute(%q) failed: %v", u.Path, err)
+				}
+				if !sameRoute(back, route) {
+					t.Errorf("upstream path %q matched %s, want %s", u.Path, routeString(back), routeString(route))
+				}
+				localOpts := opts
+				localOpts.BaseURL = ""
+				localOpts.Subreddit = back.Subreddit
+				localOpts.SortMethod = back.SortMethod
+				if got, want := constructURL(&localOpts), strings.TrimPrefix(upstream, defaultBaseURL); got != want {
+					t.Errorf("local URL = %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestMatchFeedRouteNormalizesPaths(t *testing.T) {
+	tests := []struct {
+		path      string
+		canonical string
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"//", "/"},
+		{"/hot", "/hot/"},
+		{"/TOP/", "/top/"},
+		{"/Rising//", "/rising/"},
+		{"/r/foobar", "/r/foobar/"},
+		{"/R/FooBar/", "/r/foobar/"},
+		{"/r/FooBar/Top", "/r/foobar/top/"},
+		{"//r//foobar//new//", "/r/foobar/new/"},
+		{"/r/Foo+Bar/controversial", "/r/foo+bar/controversial/"},
+		{"/r/foo_bar-baz/GILDED/", "/r/foo_bar-baz/gilded/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			route, err := matchFeedRoute(test.path)
+			if err != nil {
+				t.Fatalf("matchFeedRoute(%q) failed: %v", test.path, err)
+			}
+			if got := route.Path(); got != test.canonical {
+				t.Errorf("matchFeedRoute(%q).Path() = %q, want %q", test.path, got, test.canonical)
+			}
+		})
+	}
+}
+
+func TestMatchFeedRouteRejectsUnknownPaths(t *testing.T) {
+	paths := []string{
+		"/best/",
+		"/r/",
+		"/r/foo.bar/",
+		"/r/foobar/best/",
+		"/r/foobar/top/extra/",
+		"/u/someone/",
+		"/foobar/top/",
+	}
+
+	for _, path := range paths {
+		if route, err := matchFeedRoute(path); !errors.Is(err, ErrRouteNotFound) {
+			t.Errorf("matchFeedRoute(%q) = %s, %v, want ErrRouteNotFound", path, routeString(route), err)
+		}
+	}
+}
+
+// roundTripFunc lets a function stand in for Reddit.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestProxyHandlerRedirectsToCanonicalPath(t *testing.T) {
+
+	// Requests that aren't redirected reach Reddit, which has nothing to show
+	upstream := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    r,
+		}, nil
+	})
+	messages, err := LoadCatalog(embeddedAssets)
+	if err != nil {
+		t.Fatalf("failed to load messages: %v", err)
+	}
+	registry, err := NewTemplateRegistry(embeddedAssets, messages, &TimeFormatter{Messages: messages})
+	if err != nil {
+		t.Fatalf("failed to parse templates: %v", err)
+	}
+	handler := &ProxyHandler{
+		Parser:    &RedditParser{Client: &http.Client{Transport: upstream}},
+		Templates: registry,
+	}
+
+	tests := []struct {
+		method   string
+		target   string
+		location string // empty if the request isn't redirected
+	}{
+		{http.MethodGet, "/R/FooBar/Top", "/r/foobar/top/"},
+		{http.MethodGet, "/r/foobar", "/r/foobar/"},
+		{http.MethodGet, "/HOT?after=t3_abc&count=25", "/hot/?after=t3_abc&count=25"},
+		{http.MethodHead, "/r/FooBar/", "/r/foobar/"},
+
+		// JSON clients and form submissions are served as-is
+		{http.MethodGet, "/R/FooBar/Top.json", ""},
+		{http.MethodGet, "/r/foobar/?after=t3_abc.json", ""},
+		{http.MethodPost, "/R/FooBar/Top", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if test.location == "" {
+				if w.Code == http.StatusMovedPermanently {
+					t.Errorf("redirected to %q, want no redirect", w.Header().Get("Location"))
+				}
+				return
+			}
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusMovedPermanently)
+			}
+			if got := w.Header().Get("Location"); got != test.location {
+				t.Errorf("Location = %q, want %q", got, test.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"html/template"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// sanitizerAllowedTags lists the elements Reddit's markdown renderer produces,
+// along with the attributes we keep for each. Anything else is dropped,
+// although the children of unknown elements are still rendered.
+var sanitizerAllowedTags = map[atom.Atom][]string{
+	atom.P:          nil,
+	atom.Br:         nil,
+	atom.Hr:         nil,
+	atom.Em:         nil,
+	atom.Strong:     nil,
+	atom.Del:        nil,
+	atom.S:          nil,
+	atom.Sup:        nil,
+	atom.Sub:        nil,
+	atom.Code:       nil,
+	atom.Pre:        nil,
+	atom.Blockquote: nil,
+	atom.Ul:         nil,
+	atom.Ol:         nil,
+	atom.Li:         nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Table:      nil,
+	atom.Thead:      nil,
+	atom.Tbody:      nil,
+	atom.Tr:         nil,
+	atom.Th:         {"align"},
+	atom.Td:         {"align"},
+	atom.A:          {"href"},
+	atom.Span:       {"class"},
+}
+
+// sanitizerDroppedTags are removed along with all of their children.
+var sanitizerDroppedTags = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Textarea: true,
+	atom.Template: true,
+}
+
+// sanitizeHTML renders the children of 'root', keeping only the markup Reddit
+// uses for formatted text (see sanitizerAllowedTags). The result is safe to
+// embed in our own pages.
+func sanitizeHTML(root *html.Node) template.HTML {
+	buf := &bytes.Buffer{}
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		sanitizeNode(buf, c)
+	}
+	return template.HTML(strings.TrimSpace(buf.String()))
+}
+
+// sanitizeHTMLString sanitizes HTML that didn't come from our own parser (e.g.
+// from an imported file), in the same way as sanitizeHTML.
+func sanitizeHTMLString(s string) template.HTML {
+	if s == "" {
+		return ""
+	}
+	container := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(s), container)
+	if err != nil {
+		return ""
+	}
+	for _, n := range nodes {
+		container.AppendChild(n)
+	}
+	return sanitizeHTML(container)
+}
+
+func sanitizeNode(buf *bytes.Buffer, n *html.Node) {
+
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+		// Handled below
+	default:
+		// Comments, doctypes, etc. are dropped
+		return
+	}
+
+	if sanitizerDroppedTags[n.DataAtom] {
+		return
+	}
+
+	allowedAttrs, ok := sanitizerAllowedTags[n.DataAtom]
+	if !ok {
+		// Unwrap unknown elements
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			sanitizeNode(buf, c)
+		}
+		return
+	}
+
+	buf.WriteString("<")
+	buf.WriteString(n.DataAtom.String())
+	for _, attr := range n.Attr {
+		if !slices.Contains(allowedAttrs, attr.Key) {
+			continue
+		}
+		val, ok := sanitizeAttribute(n.DataAtom, attr.Key, attr.Val)
+		if !ok {
+			continue
+		}
+		buf.WriteString(" ")
+		buf.WriteString(attr.Key)
+		buf.WriteString(`="`)
+		buf.WriteString(html.EscapeString(val))
+		buf.WriteString(`"`)
+	}
+	if n.DataAtom == atom.A {
+		buf.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	buf.WriteString(">")
+
+	// Void elements have no children or closing tag
+	if n.DataAtom == atom.Br || n.DataAtom == atom.Hr {
+		return
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sanitizeNode(buf, c)
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(n.DataAtom.String())
+	buf.WriteString(">")
+}
+
+// sanitizeAttribute validates the value of an allowed attribute, returning
+// false if the attribute should be dropped.
+func sanitizeAttribute(tag atom.Atom, key string, val string) (string, bool) {
+	switch {
+	case tag == atom.A && key == "href":
+		u, err := url.Parse(strings.TrimSpace(val))
+		if err != nil {
+			return "", false
+		}
+		switch u.Scheme {
+		case "", "http", "https":
+			return u.String(), true
+		default:
+			// e.g. "javascript:"
+			return "", false
+		}
+
+	case tag == atom.Span && key == "class":
+		// Spoiler text is the only styled span in Reddit's markdown
+		if val == "md-spoiler-text" {
+			return val, true
+		}
+		return "", false
+
+	case key == "align":
+		switch val {
+		case "left", "center", "right":
+			return val, true
+		}
+		return "", false
+
+	default:
+		return val, true
+	}
+}
+
+// plainText renders the text of 'root' with paragraph breaks between block
+// elements, approximating the markdown the HTML was generated from.
+func plainText(root *html.Node) string {
+	var sb strings.Builder
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if sanitizerDroppedTags[n.DataAtom] {
+				return
+			}
+		}
+
+		if n.DataAtom == atom.Li {
+			sb.WriteString("- ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+
+		switch n.DataAtom {
+		case atom.Br, atom.Li, atom.Tr:
+			sb.WriteString("\n")
+		case atom.P, atom.Pre, atom.Blockquote, atom.Ul, atom.Ol, atom.Table,
+			atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Hr:
+			sb.WriteString("\n\n")
+		}
+	}
+	walk(root)
+
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSanitizeHTMLString(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"plain text", "a < b & c", "a &lt; b &amp; c"},
+		{"formatting", "<p><strong>bold</strong> <em>it</em></p>", "<p><strong>bold</strong> <em>it</em></p>"},
+		{"void elements", "a<br>b<hr>", "a<br>b<hr>"},
+
+		// Links
+		{"link", `<a href="https://example.com/?a=1&amp;b=2">l</a>`,
+			`<a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener noreferrer">l</a>`},
+		{"relative link", `<a href="/r/foo">l</a>`, `<a href="/r/foo" rel="nofollow noopener noreferrer">l</a>`},
+		{"own rel replaced", `<a href="/r/foo" rel="opener" target="_blank">l</a>`,
+			`<a href="/r/foo" rel="nofollow noopener noreferrer">l</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">l</a>`, `<a rel="nofollow noopener noreferrer">l</a>`},
+		{"upper case javascript link", `<a href=" JavaScript:alert(1)">l</a>`, `<a rel="nofollow noopener noreferrer">l</a>`},
+		{"obfuscated javascript link", "<a href=\"java\tscript:alert(1)\">l</a>", `<a rel="nofollow noopener noreferrer">l</a>`},
+		{"data link", `<a href="data:text/html,<script>alert(1)</script>">l</a>`, `<a rel="nofollow noopener noreferrer">l</a>`},
+		{"vbscript link", `<a href="vbscript:msgbox(1)">l</a>`, `<a rel="nofollow noopener noreferrer">l</a>`},
+
+		// Attributes
+		{"event handler", `<p onclick="alert(1)">x</p>`, "<p>x</p>"},
+		{"event handler on link", `<a href="/x" onmouseover="alert(1)">l</a>`, `<a href="/x" rel="nofollow noopener noreferrer">l</a>`},
+		{"style", `<p style="position:fixed">x</p>`, "<p>x</p>"},
+		{"table alignment", `<table><tr><td align="center" valign="top">x</td></tr></table>`,
+			`<table><tbody><tr><td align="center">x</td></tr></tbody></table>`},
+		{"bad alignment", `<table><tr><th align="javascript:">x</th></tr></table>`,
+			`<table><tbody><tr><th>x</th></tr></tbody></table>`},
+
+		// Spans only keep the spoiler class
+		{"spoiler", `<span class="md-spoiler-text">s</span>`, `<span class="md-spoiler-text">s</span>`},
+		{"other class", `<span class="overlay">s</span>`, `<span>s</span>`},
+		{"spoiler with other classes", `<span class="md-spoiler-text overlay">s</span>`, `<span>s</span>`},
+		{"class elsewhere", `<p class="md-spoiler-text">s</p>`, `<p>s</p>`},
+
+		// Dropped elements lose their children, unknown ones are unwrapped
+		{"script", `a<script>alert(1)</script>b`, "ab"},
+		{"style element", `a<style>body{display:none}</style>b`, "ab"},
+		{"iframe", `<iframe src="https://evil.test"></iframe>x`, "x"},
+		{"form", `<form action="/x"><input name="a"><button>go</button></form>x`, "x"},
+		{"unknown element", `<div><font color="red">x</font></div>`, "x"},
+		{"image", `<img src="x" onerror="alert(1)">x`, "x"},
+		{"nested", `<div><p><b><script>alert(1)</script>a</b><em onclick="x">b</em></p></div>`, "<p>a<em>b</em></p>"},
+		{"script in unknown element", `<svg><script>alert(1)</script>x</svg>`, "x"},
+		{"comment", `a<!-- <script>alert(1)</script> -->b`, "ab"},
+		{"unclosed", `<p><strong>x`, "<p><strong>x</strong></p>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(sanitizeHTMLString(test.in)); got != test.want {
+				t.Errorf("sanitizeHTMLString(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	savedFileName = "saved.json"
+	savedPath     = "/saved"
+
+	maxSavedNoteLength = 2000
+	maxSavedTags       = 20
+
+	// maxSavedImportBytes bounds imported files, which hold whole posts
+	// (including the bodies of text posts).
+	maxSavedImportBytes = 32 << 20
+)
+
+var (
+	ErrInvalidTag    = errors.New("invalid tag")
+	ErrNoteTooLong   = errors.New("note too long")
+	ErrNotSaved      = errors.New("post is not saved")
+	ErrInvalidExport = errors.New("invalid saved posts file")
+)
+
+// tagRegex matches a single tag: letters, numbers, '_' and '-'.
+var tagRegex = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+// SavedPost is a post the user has saved locally, along with their own tags and
+// notes. The post is a snapshot from when it was saved, so it's still there if
+// it's later edited or removed on Reddit.
+type SavedPost struct {
+	Post    FeedPost  `json:"post"`
+	Tags    []string  `json:"tags"`
+	Note    string    `json:"note"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// TagString returns the tags separated by spaces, the way they're edited.
+func (sp SavedPost) TagString() string {
+	return strings.Join(sp.Tags, " ")
+}
+
+// SavedFilter narrows down the saved posts listed by SavedStore.List. Empty
+// fields match every post.
+type SavedFilter struct {
+	Tag       string
+	Subreddit string
+}
+
+// Matches reports whether 'sp' passes the filter.
+func (sf SavedFilter) Matches(sp SavedPost) bool {
+	if sf.Tag != "" && !slices.Contains(sp.Tags, sf.Tag) {
+		return false
+	}
+	if sf.Subreddit != "" && !strings.EqualFold(sp.Post.Subreddit, sf.Subreddit) {
+		return false
+	}
+	return true
+}
+
+// SavedStore keeps the user's saved posts in a JSON file in the data
+// directory. It's safe for concurrent use.
+type SavedStore struct {
+	path string
+	now  func() time.Time
+
+	mu    sync.Mutex
+	saved []SavedPost // most recently saved first
+}
+
+// savedList is the format of the saved posts file, and of exports.
+type savedList struct {
+	Saved []SavedPost `json:"saved"`
+}
+
+// OpenSavedStore loads the saved posts kept in 'dataDir', if any.
+func OpenSavedStore(dataDir string) (*SavedStore, error) {
+	ss := &SavedStore{
+		path: filepath.Join(dataDir, savedFileName),
+		now:  time.Now,
+	}
+
+	var file savedList
+	if err := readJSONFile(ss.path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ss.path, err)
+	}
+	ss.saved = file.Saved
+	slices.SortStableFunc(ss.saved, compareSavedPosts)
+	return ss, nil
+}
+
+// List returns the saved posts that pass 'filter', most recently saved first.
+// The slice is never nil.
+func (ss *SavedStore) List(filter SavedFilter) []SavedPost {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	saved := []SavedPost{}
+	for _, sp := range ss.saved {
+		if filter.Matches(sp) {
+			saved = append(saved, sp)
+		}
+	}
+	return saved
+}
+
+// IsSaved reports whether the post 'id' is saved.
+func (ss *SavedStore) IsSaved(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.index(id) >= 0
+}
+
+// Tags lists every tag in use, sorted. They're offered as filters.
+func (ss *SavedStore) Tags() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var tags []string
+	for _, sp := range ss.saved {
+		tags = append(tags, sp.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// Subreddits lists every subreddit with saved posts, sorted and in lower case.
+// They're offered as filters.
+func (ss *SavedStore) Subreddits() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var subreddits []string
+	for _, sp := range ss.saved {
+		if sp.Post.Subreddit != "" {
+			subreddits = append(subreddits, strings.ToLower(sp.Post.Subreddit))
+		}
+	}
+	slices.Sort(subreddits)
+	return slices.Compact(subreddits)
+}
+
+// Save stores a snapshot of 'post'. If it's already saved, the snapshot is
+// replaced but its tags, note and time are kept.
+func (ss *SavedStore) Save(post FeedPost) error {
+
+	if !postIDRegex.MatchString(post.ID) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidPostID, post.ID)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	saved := slices.Clone(ss.saved)
+	if i := ss.index(post.ID); i >= 0 {
+		saved[i].Post = post
+	} else {
+		saved = slices.Insert(saved, 0, SavedPost{
+			Post:    post,
+			Tags:    []string{},
+			SavedAt: ss.now().UTC().Truncate(time.Second),
+		})
+	}
+	return ss.save(saved)
+}
+
+// Annotate replaces the tags and note of the saved post 'id'. Tags are
+// normalized (see normalizeTags).
+func (ss *SavedStore) Annotate(id string, tags []string, note string) error {
+
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return err
+	}
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > maxSavedNoteLength {
+		return fmt.Errorf("%w: the limit is %d characters", ErrNoteTooLong, maxSavedNoteLength)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	i := ss.index(id)
+	if i < 0 {
+		return ErrNotSaved
+	}
+	saved := slices.Clone(ss.saved)
+	saved[i].Tags = tags
+	saved[i].Note = note
+	return ss.save(saved)
+}
+
+// Remove deletes the saved post 'id'.
+func (ss *SavedStore) Remove(id string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	i := ss.index(id)
+	if i < 0 {
+		return ErrNotSaved
+	}
+	return ss.save(slices.Delete(slices.Clone(ss.saved), i, i+1))
+}
+
+// Import merges saved posts from an export (see savedList). Imported posts
+// replace saved posts with the same ID, and their HTML is sanitized again.
+// Nothing is imported if any post is invalid.
+func (ss *SavedStore) Import(imported []SavedPost) error {
+
+	for i := range imported {
+		sp := &imported[i]
+		if !postIDRegex.MatchString(sp.Post.ID) {
+			return fmt.Errorf("%w: %w: '%s'", ErrInvalidExport, ErrInvalidPostID, sp.Post.ID)
+		}
+		tags, err := normalizeTags(sp.Tags)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidExport, err)
+		}
+		sp.Tags = tags
+
+		// Exports can be edited (or crafted), so their HTML can't be trusted
+		// the way the parser's output is
+		sp.Post.SelfTextHTML = sanitizeHTMLString(string(sp.Post.SelfTextHTML))
+		sanitizeImportedMedia(&sp.Post)
+		if sp.SavedAt.IsZero() {
+			sp.SavedAt = ss.now().UTC().Truncate(time.Second)
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	saved := slices.Clone(ss.saved)
+	for _, sp := range imported {
+		if i := slices.IndexFunc(saved, func(s SavedPost) bool { return s.Post.ID == sp.Post.ID }); i >= 0 {
+			saved[i] = sp
+		} else {
+			saved = append(saved, sp)
+		}
+	}
+	slices.SortStableFunc(saved, compareSavedPosts)
+	return ss.save(saved)
+}
+
+// sanitizeImportedMedia drops the links and media of an imported post that the
+// parser would never have produced: URLs that aren't http(s), embeds that
+// don't follow from the post's link, and videos without usable sources. The
+// post's own links may also be paths on this server, like those of self posts.
+func sanitizeImportedMedia(post *FeedPost) {
+
+	if !isWebURL(post.PostLink) && !isLocalPath(post.PostLink) {
+		post.PostLink = ""
+	}
+	if !isWebURL(post.CommentsLink) && !isLocalPath(post.CommentsLink) {
+		post.CommentsLink = ""
+	}
+	if !isWebURL(post.ThumbnailLink) {
+		post.ThumbnailLink = ""
+	}
+	post.Images = slices.DeleteFunc(post.Images, func(iv ImageVariant) bool {
+		return !isWebURL(iv.URL)
+	})
+	if len(post.Images) == 0 {
+		post.Images = nil
+	}
+
+	// Embeds only ever come from the post's link (see resolveMedia), so any
+	// other embed has been tampered with
+	if post.Embed != nil {
+		if _, embed := resolveMedia(post); embed == nil || *embed != *post.Embed {
+			post.Embed = nil
+		}
+	}
+	if post.Video != nil && !isValidVideo(post.Video) {
+		post.Video = nil
+	}
+}
+
+// isValidVideo reports whether every URL of 'video' is http(s), and it has at
+// least one source the player knows.
+func isValidVideo(video *RedditVideo) bool {
+	if len(video.Sources) == 0 {
+		return false
+	}
+	for _, source := range video.Sources {
+		switch source.Kind {
+		case VideoSourceDASH, VideoSourceHLS, VideoSourceMP4:
+		default:
+			return false
+		}
+		if !isWebURL(source.URL) {
+			return false
+		}
+	}
+	for _, link := range []string{video.DASHURL, video.HLSURL, video.FallbackURL} {
+		if link != "" && !isWebURL(link) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWebURL reports whether 's' is an absolute http(s) URL.
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isLocalPath reports whether 's' is a path on this server. Browsers read
+// paths starting with "//" or "/\" as another host, so those aren't.
+func isLocalPath(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "" && u.Host == "" &&
+		strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
+
+// index returns the position of the post 'id', or -1. The caller must hold
+// ss.mu.
+func (ss *SavedStore) index(id string) int {
+	return slices.IndexFunc(ss.saved, func(sp SavedPost) bool {
+		return sp.Post.ID == id
+	})
+}
+
+// save writes 'saved' to disk, and only then makes it current. The caller
+// must hold ss.mu.
+func (ss *SavedStore) save(saved []SavedPost) error {
+	if err := writeJSONFile(ss.path, savedList{Saved: saved}); err != nil {
+		return fmt.Errorf("failed to save posts: %w", err)
+	}
+	ss.saved = saved
+	return nil
+}
+
+// markSaved sets FeedPost.Saved on the posts of 'feed' that are saved.
+func (ss *SavedStore) markSaved(feed *Feed) {
+	for i := range feed.Posts {
+		feed.Posts[i].Saved = ss.IsSaved(feed.Posts[i].ID)
+	}
+}
+
+// compareSavedPosts orders saved posts from the most recently saved.
+func compareSavedPosts(a, b SavedPost) int {
+	return cmp.Compare(b.SavedAt.Unix(), a.SavedAt.Unix())
+}
+
+// normalizeTags lower cases 'tags' and removes duplicates, keeping their
+// order. It fails if any tag is invalid, or if there are too many.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if !tagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidTag, tag)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxSavedTags {
+		return nil, fmt.Errorf("%w: the limit is %d tags", ErrInvalidTag, maxSavedTags)
+	}
+	return normalized, nil
+}
+
+// ------------------------------------------------------------------------- //
+// Handlers
+// ------------------------------------------------------------------------- //
+
+// SavedView describes the saved posts page. It's passed to the feed templates
+// alongside the saved posts, which are rendered like any other feed.
+type SavedView struct {
+	Filter     SavedFilter
+	Tags       []string
+	Subreddits []string
+
+	// Entries holds the tags and notes of the posts on the page, by post ID.
+	Entries map[string]*SavedPost
+}
+
+// Link returns the saved posts page filtered by 'tag' and 'subreddit'.
+func (sv *SavedView) Link(tag string, subreddit string) string {
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if subreddit != "" {
+		query.Set("subreddit", subreddit)
+	}
+	if len(query) == 0 {
+		return savedPath
+	}
+	return savedPath + "?" + query.Encode()
+}
+
+// SavedHandler serves the saved posts as a feed, and the forms that manage
+// them:
+//
+//	GET  [root]/saved?tag=[tag]&subreddit=[subreddit]
+//	GET  [root]/saved.json?tag=[tag]&subreddit=[subreddit]
+//	POST [root]/saved               id=[post_id]
+//	POST [root]/saved/edit          id=[post_id]&tags=[tags]&note=[note]
+//	POST [root]/saved/remove        id=[post_id]
+//	GET  [root]/saved/export
+//	POST [root]/saved/import        file=[export]
+//
+// Saving a post downloads a fresh copy of it from Reddit. Forms redirect back
+// to the page they were submitted from.
+type SavedHandler struct {
+	Feeds *ProxyHandler
+	Store *SavedStore
+}
+
+// Register adds the handler's routes to 'mux', wrapping each with 'wrap' (e.g.
+// loggingHandler).
+func (sh *SavedHandler) Register(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	handle := func(pattern string, f http.HandlerFunc) {
+		mux.Handle(pattern, wrap(f))
+	}
+	guarded := func(pattern string, f http.HandlerFunc) {
+		mux.Handle(pattern, wrap(sameOriginHandler(sh.Feeds.Templates, f)))
+	}
+	handle("GET "+savedPath, sh.serveFeed)
+	handle("GET "+savedPath+".json", sh.serveFeed)
+	guarded("POST "+savedPath, sh.serveSave)
+	guarded("POST "+savedPath+"/edit", sh.serveEdit)
+	guarded("POST "+savedPath+"/remove", sh.serveRemove)
+	handle("GET "+savedPath+"/export", sh.serveExport)
+	guarded("POST "+savedPath+"/import", sh.serveImport)
+}
+
+func (sh *SavedHandler) serveFeed(w http.ResponseWriter, r *http.Request) {
+
+	outputJSON := trimJSONSuffix(r)
+	query := r.URL.Query()
+	filter := SavedFilter{
+		Tag:       strings.ToLower(query.Get("tag")),
+		Subreddit: strings.ToLower(query.Get("subreddit")),
+	}
+
+	saved := sh.Store.List(filter)
+	view := &SavedView{
+		Filter:     filter,
+		Tags:       sh.Store.Tags(),
+		Subreddits: sh.Store.Subreddits(),
+		Entries:    make(map[string]*SavedPost, len(saved)),
+	}
+	feed := &Feed{Posts: make([]FeedPost, len(saved)), Page: 1}
+	for i := range saved {
+		feed.Posts[i] = saved[i].Post
+		feed.Posts[i].Saved = true
+		view.Entries[saved[i].Post.ID] = &saved[i]
+	}
+
+	page := FeedPage{Feed: feed, Prefs: readPreferences(r, sh.Feeds.Templates), SavedView: view}
+	sh.Feeds.writeFeed(w, r, page, outputJSON)
+}
+
+func (sh *SavedHandler) serveSave(w http.ResponseWriter, r *http.Request) {
+
+	id := r.PostFormValue("id")
+	if !postIDRegex.MatchString(id) {
+		sh.afterForm(w, r, fmt.Errorf("%w: '%s'", ErrInvalidPostID, id))
+		return
+	}
+
+	ctx := r.Context()
+	if sh.Feeds.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sh.Feeds.RequestTimeout)
+		defer cancel()
+	}
+	post, err := sh.Feeds.Parser.Post(ctx, id, WithHeaders(upstreamHeaders(r)))
+	if err != nil {
+		sh.afterForm(w, r, err)
+		return
+	}
+	sh.afterForm(w, r, sh.Store.Save(*post))
+}
+
+func (sh *SavedHandler) serveEdit(w http.ResponseWriter, r *http.Request) {
+	err := sh.Store.Annotate(r.PostFormValue("id"), splitList(r.PostFormValue("tags")), r.PostFormValue("note"))
+	sh.afterForm(w, r, err)
+}
+
+func (sh *SavedHandler) serveRemove(w http.ResponseWriter, r *http.Request) {
+	sh.afterForm(w, r, sh.Store.Remove(r.PostFormValue("id")))
+}
+
+func (sh *SavedHandler) serveExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Disposition", `attachment; filename="saved.json"`)
+	writeJSON(w, r, sh.Feeds.Templates, savedList{Saved: sh.Store.List(SavedFilter{})})
+}
+
+func (sh *SavedHandler) serveImport(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSavedImportBytes)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		sh.afterForm(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var export savedList
+	if err := json.NewDecoder(file).Decode(&export); err != nil {
+		sh.afterForm(w, r, fmt.Errorf("%w: %v", ErrInvalidExport, err))
+		return
+	}
+	sh.afterForm(w, r, sh.Store.Import(export.Saved))
+}
+
+// afterForm redirects back to the page a form was submitted from, or reports
+// 'err' on an error page.
+func (sh *SavedHandler) afterForm(w http.ResponseWriter, r *http.Request, err error) {
+
+	if err != nil {
+		if r.Context().Err() != nil {
+			logF(LevelDebug, "Client went away: %v", err)
+			return
+		}
+		info := describeError(err)
+		if info.StatusCode >= http.StatusInternalServerError {
+			logF(LevelError, "Failed to update saved posts: %v", err)
+		}
+		info.RetryLink = localReferer(r, savedPath)
+		writeError(w, r, sh.Feeds.Templates, false, info)
+		return
+	}
+	http.Redirect(w, r, localReferer(r, savedPath), http.StatusSeeOther)
+}
+
+// localReferer returns the path and query of the page 'r' was submitted from,
+// so it can be redirected back there. Only the path and query are used, so the
+// redirect can't leave this server. Without a referer, 'fallback' is returned.
+func localReferer(r *http.Request, fallback string) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return fallback
+	}
+	return (&url.URL{Path: u.Path, RawQuery: u.RawQuery}).RequestURI()
+}
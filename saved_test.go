@@ -0,0 +1,129 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSavedStoreImportDropsUnsafeMedia(t *testing.T) {
+	store, err := OpenSavedStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	mp4 := []VideoSource{{Kind: VideoSourceMP4, URL: "https://v.redd.it/abc/DASH_720.mp4", MIMEType: "video/mp4"}}
+	youtube := &MediaEmbed{Kind: EmbedKindIFrame, Provider: "youtube", URL: "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"}
+
+	tests := []struct {
+		name string
+		post FeedPost
+		want FeedPost
+	}{
+		{
+			name: "valid media is kept",
+			post: FeedPost{
+				PostLink:      "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				CommentsLink:  "/r/foo/comments/abc/title/",
+				ThumbnailLink: "https://b.thumbs.redditmedia.com/x.jpg",
+				Embed:         youtube,
+				Images:        []ImageVariant{{URL: "https://preview.redd.it/x.jpg", Width: 640}},
+				Video:         &RedditVideo{HLSURL: "https://v.redd.it/abc/HLSPlaylist.m3u8", Sources: mp4},
+			},
+			want: FeedPost{
+				PostLink:      "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				CommentsLink:  "/r/foo/comments/abc/title/",
+				ThumbnailLink: "https://b.thumbs.redditmedia.com/x.jpg",
+				Embed:         youtube,
+				Images:        []ImageVariant{{URL: "https://preview.redd.it/x.jpg", Width: 640}},
+				Video:         &RedditVideo{HLSURL: "https://v.redd.it/abc/HLSPlaylist.m3u8", Sources: mp4},
+			},
+		},
+		{
+			name: "script links",
+			post: FeedPost{
+				PostLink:      "javascript:alert(1)",
+				CommentsLink:  "//evil.test/r/foo/",
+				ThumbnailLink: "data:image/svg+xml,<svg/>",
+			},
+			want: FeedPost{},
+		},
+		{
+			name: "backslash path",
+			post: FeedPost{PostLink: "/\\evil.test/"},
+			want: FeedPost{},
+		},
+		{
+			name: "embed that doesn't match the link",
+			post: FeedPost{
+				PostLink: "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				Embed:    &MediaEmbed{Kind: EmbedKindIFrame, Provider: "youtube", URL: "https://evil.test/"},
+			},
+			want: FeedPost{PostLink: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		},
+		{
+			name: "embed without a link",
+			post: FeedPost{Embed: youtube},
+			want: FeedPost{},
+		},
+		{
+			name: "images",
+			post: FeedPost{Images: []ImageVariant{
+				{URL: "javascript:alert(1)"},
+				{URL: "https://preview.redd.it/x.jpg"},
+				{URL: "ftp://preview.redd.it/x.jpg"},
+			}},
+			want: FeedPost{Images: []ImageVariant{{URL: "https://preview.redd.it/x.jpg"}}},
+		},
+		{
+			name: "video without sources",
+			post: FeedPost{Video: &RedditVideo{HLSURL: "https://v.redd.it/abc/HLSPlaylist.m3u8"}},
+			want: FeedPost{},
+		},
+		{
+			name: "video with a script source",
+			post: FeedPost{Video: &RedditVideo{Sources: []VideoSource{{Kind: VideoSourceMP4, URL: "javascript:alert(1)"}}}},
+			want: FeedPost{},
+		},
+		{
+			name: "video with an unknown source",
+			post: FeedPost{Video: &RedditVideo{Sources: []VideoSource{{Kind: "flash", URL: "https://v.redd.it/abc.swf"}}}},
+			want: FeedPost{},
+		},
+		{
+			name: "video with a script fallback",
+			post: FeedPost{Video: &RedditVideo{FallbackURL: "javascript:alert(1)", Sources: mp4}},
+			want: FeedPost{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.post.ID = "t3_abc"
+			imported := []SavedPost{{Post: test.post, SavedAt: time.Unix(0, 0)}}
+			if err := store.Import(imported); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			got := store.List(SavedFilter{})[0].Post
+
+			if got.PostLink != test.want.PostLink {
+				t.Errorf("PostLink = %q, want %q", got.PostLink, test.want.PostLink)
+			}
+			if got.CommentsLink != test.want.CommentsLink {
+				t.Errorf("CommentsLink = %q, want %q", got.CommentsLink, test.want.CommentsLink)
+			}
+			if got.ThumbnailLink != test.want.ThumbnailLink {
+				t.Errorf("ThumbnailLink = %q, want %q", got.ThumbnailLink, test.want.ThumbnailLink)
+			}
+			if (got.Embed == nil) != (test.want.Embed == nil) || got.Embed != nil && *got.Embed != *test.want.Embed {
+				t.Errorf("Embed = %+v, want %+v", got.Embed, test.want.Embed)
+			}
+			if !slices.Equal(got.Images, test.want.Images) {
+				t.Errorf("Images = %+v, want %+v", got.Images, test.want.Images)
+			}
+			if (got.Video == nil) != (test.want.Video == nil) {
+				t.Errorf("Video = %+v, want %+v", got.Video, test.want.Video)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 	"regexp"
+	"strings"
 )
 
 var (
@@ -83,6 +84,32 @@ func DepthFirstSearch(
 	return nil, ErrSearchFailed
 }
 
+// FindAll returns every node below 'root' (inclusive) that matches 'criteria',
+// in document order. Unlike the searches above, it doesn't stop at the first
+// match, and matching nodes are still searched for nested matches.
+func FindAll(
+	root *html.Node,
+	criteria SearchCriteria,
+	recurseIf SearchCriteria,
+) []*html.Node {
+	var matches []*html.Node
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if criteria(node) {
+			matches = append(matches, node)
+		}
+		if node.Type == html.DocumentNode || recurseIf(node) {
+			for c := node.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+
+	return matches
+}
+
 func NthChild(
 	node *html.Node,
 	n int,
@@ -113,6 +140,45 @@ func GetAttribute(node *html.Node, attributeKey string) (string, bool) {
 	return "", false
 }
 
+// ParseFragment parses an HTML fragment (e.g. the contents of an attribute that
+// holds escaped markup), returning a <div> containing the parsed nodes.
+func ParseFragment(fragment string) (*html.Node, error) {
+	container := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Div,
+		Data:     atom.Div.String(),
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), container)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		container.AppendChild(n)
+	}
+
+	return container, nil
+}
+
+// TextContent returns the concatenated text of 'node' and all of its
+// descendants, similar to the DOM property of the same name.
+func TextContent(node *html.Node) string {
+	var sb strings.Builder
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(node)
+
+	return sb.String()
+}
+
 // ------------------------------------------------------------------------- //
 // Search Criteria
 // ------------------------------------------------------------------------- //
@@ -192,6 +258,23 @@ func HasAttributeWithValueRegex(attributeKey string, attributeRegex string) Sear
 	}
 }
 
+// HasClass matches nodes whose space separated "class" attribute contains
+// 'className' as one of its entries.
+func HasClass(className string) SearchCriteria {
+	return func(node *html.Node) bool {
+		classes, ok := GetAttribute(node, "class")
+		if !ok {
+			return false
+		}
+		for _, c := range strings.Fields(classes) {
+			if c == className {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func RecurseAlways(_ *html.Node) bool {
 	return true
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	seenFileName = "seen.json"
+
+	// defaultSeenExpiry is how long posts stay marked as seen. Feeds rarely
+	// show posts older than a few days, so there's little point keeping them
+	// much longer.
+	defaultSeenExpiry = 30 * 24 * time.Hour
+
+	// maxSeenPerRequest bounds how many posts a single request can mark.
+	maxSeenPerRequest = 500
+)
+
+var (
+	ErrInvalidPostID = errors.New("invalid post ID")
+)
+
+// postIDRegex matches the IDs of FeedPosts, which are Reddit's "fullnames" for
+// links (e.g. "t3_1a2b3c").
+var postIDRegex = regexp.MustCompile(`^t3_[a-z0-9]{1,13}$`)
+
+// SeenStore remembers which posts the user has already seen, so that feeds can
+// mark or hide them (see SeenPolicy). Posts are forgotten once they haven't
+// been seen for longer than the expiry, which keeps the file from growing
+// without bound. It's safe for concurrent use.
+type SeenStore struct {
+	path   string
+	expiry time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time // post ID -> when it was last seen
+}
+
+type seenFile struct {
+	Posts map[string]time.Time `json:"posts"`
+}
+
+// OpenSeenStore loads the seen posts saved in 'dataDir', if any, dropping the
+// ones that have expired.
+func OpenSeenStore(dataDir string, expiry time.Duration) (*SeenStore, error) {
+	ss := &SeenStore{
+		path:   filepath.Join(dataDir, seenFileName),
+		expiry: expiry,
+		now:    time.Now,
+	}
+
+	var file seenFile
+	if err := readJSONFile(ss.path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ss.path, err)
+	}
+	ss.seen = ss.unexpired(file.Posts)
+	return ss, nil
+}
+
+// IsSeen reports whether the post 'id' has been seen and hasn't expired yet.
+func (ss *SeenStore) IsSeen(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	seen, ok := ss.seen[id]
+	return ok && ss.now().Sub(seen) < ss.expiry
+}
+
+// Mark records that the posts 'ids' have been seen now. Nothing is recorded if
+// any ID is invalid.
+func (ss *SeenStore) Mark(ids ...string) error {
+
+	if len(ids) > maxSeenPerRequest {
+		return fmt.Errorf("%w: too many posts (%d)", ErrBadRequest, len(ids))
+	}
+	for _, id := range ids {
+		if !postIDRegex.MatchString(id) {
+			return fmt.Errorf("%w: '%s'", ErrInvalidPostID, id)
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	// Expired posts are dropped whenever the file is written
+	seen := ss.unexpired(ss.seen)
+	now := ss.now().UTC().Truncate(time.Second)
+	for _, id := range ids {
+		seen[id] = now
+	}
+	return ss.save(seen)
+}
+
+// Clear forgets every seen post.
+func (ss *SeenStore) Clear() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.save(map[string]time.Time{})
+}
+
+// unexpired returns a copy of 'seen' without the posts that have expired.
+func (ss *SeenStore) unexpired(seen map[string]time.Time) map[string]time.Time {
+	now := ss.now()
+	out := maps.Clone(seen)
+	if out == nil {
+		out = map[string]time.Time{}
+	}
+	maps.DeleteFunc(out, func(_ string, t time.Time) bool {
+		return now.Sub(t) >= ss.expiry
+	})
+	return out
+}
+
+// save writes 'seen' to disk, and only then makes it current. The caller must
+// hold ss.mu.
+func (ss *SeenStore) save(seen map[string]time.Time) error {
+	if err := writeJSONFile(ss.path, seenFile{Posts: seen}); err != nil {
+		return fmt.Errorf("failed to save seen posts: %w", err)
+	}
+	ss.seen = seen
+	return nil
+}
+
+// applySeenPolicy marks the posts of 'feed' the user has already seen, or
+// removes them if 'policy' says so. Removing posts leaves the feed's paging
+// cursors alone, since they come from Reddit and still point at the pages
+// either side of this one. The number of removed posts is kept in
+// Feed.HiddenSeen, so an emptied page can explain itself.
+func applySeenPolicy(feed *Feed, seen *SeenStore, policy SeenPolicy) {
+	posts := feed.Posts[:0]
+	for _, post := range feed.Posts {
+		post.Seen = seen.IsSeen(post.ID)
+		if post.Seen && policy == SeenPolicyHide {
+			feed.HiddenSeen++
+			continue
+		}
+		posts = append(posts, post)
+	}
+	feed.Posts = posts
+}
+
+// SeenHandler records the posts the user has seen, as reported by seen.js. It
+// responds with 204 No Content. It's mounted behind sameOriginHandler, so
+// other sites can't mark or clear the user's posts.
+//
+//	POST   [root]/api/seen
+//	  id=[post_id]&id=[post_id]...
+//	DELETE [root]/api/seen
+type SeenHandler struct {
+	Store     *SeenStore
+	Templates *TemplateRegistry
+}
+
+func (sh *SeenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxAPIRequestBytes)
+		if err = r.ParseForm(); err != nil {
+			err = fmt.Errorf("%w: %v", ErrBadRequest, err)
+		} else {
+			err = sh.Store.Mark(r.PostForm["id"]...)
+		}
+	case http.MethodDelete:
+		err = sh.Store.Clear()
+	default:
+		w.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+		writeError(w, r, sh.Templates, true, ErrorInfo{
+			StatusCode: http.StatusMethodNotAllowed,
+			Code:       ErrorCodeMethodNotAllowed,
+			Title:      http.StatusText(http.StatusMethodNotAllowed),
+			Message:    "Seen posts can only be marked or cleared.",
+		})
+		return
+	}
+
+	if err != nil {
+		info := describeError(err)
+		if info.StatusCode >= http.StatusInternalServerError {
+			logF(LevelError, "Failed to update seen posts: %v", err)
+		}
+		writeError(w, r, sh.Templates, true, info)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// Server timeouts
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 10 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+
+	// writeTimeoutMargin is added to the request timeout to get the write
+	// timeout, leaving time to render and send a page once it's been fetched.
+	writeTimeoutMargin = 15 * time.Second
+
+	// Self-signed certificates are generated anew on every start, so their
+	// lifetime only needs to outlast a long running development server.
+	selfSignedCertLifetime = 365 * 24 * time.Hour
+)
+
+type ServerConfig struct {
+
+	// Addr is the TCP address to listen on (e.g. ":8080"). It is ignored when
+	// UnixSocket is set.
+	Addr string
+
+	// UnixSocket, when non-empty, is the path of a Unix domain socket to
+	// listen on instead of a TCP port. This is mostly useful when running
+	// behind a reverse proxy on the same host.
+	UnixSocket string
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are provided.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSSelfSigned enables HTTPS with a freshly generated self-signed
+	// certificate. This is intended for development only.
+	TLSSelfSigned bool
+
+	// WriteTimeout bounds how long a response may take, from the end of the
+	// request headers. Zero means no limit. It should leave room for the
+	// request timeout (see writeTimeoutFor), or slow pages are cut off before
+	// their error page can be sent.
+	WriteTimeout time.Duration
+
+	// ShutdownTimeout bounds how long we wait for in-flight requests to drain
+	// once a shutdown has been requested.
+	ShutdownTimeout time.Duration
+}
+
+// writeTimeoutFor returns the write timeout that lets requests run for
+// 'requestTimeout'. Without a request timeout, there's no write timeout
+// either.
+func writeTimeoutFor(requestTimeout time.Duration) time.Duration {
+	if requestTimeout <= 0 {
+		return 0
+	}
+	return requestTimeout + writeTimeoutMargin
+}
+
+// runServer serves 'handler' until 'ctx' is cancelled, at which point the
+// server stops accepting new connections and waits (up to
+// cfg.ShutdownTimeout) for in-flight requests to complete.
+func runServer(ctx context.Context, cfg ServerConfig, handler http.Handler) error {
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       defaultReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+	}
+
+	listener, err := listen(cfg)
+	if err != nil {
+		return err
+	}
+
+	useTLS := cfg.TLSSelfSigned || (cfg.TLSCertFile != "" && cfg.TLSKeyFile != "")
+	if cfg.TLSSelfSigned {
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			_ = listener.Close()
+			return fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+	} else if useTLS {
+		server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	// Serve in the background so we can wait for either a fatal error or a
+	// shutdown request.
+	errCh := make(chan error, 1)
+	go func() {
+		logF(LevelInfo, "Listening on %s (tls=%t)", listener.Addr(), useTLS)
+		if useTLS {
+			// Empty file names are fine when the certificate has already
+			// been placed in the TLS config.
+			certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+			if cfg.TLSSelfSigned {
+				certFile, keyFile = "", ""
+			}
+			errCh <- server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			errCh <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting new connections and let in-flight requests drain
+	logF(LevelInfo, "Shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	logF(LevelInfo, "Server stopped")
+	return nil
+}
+
+func listen(cfg ServerConfig) (net.Listener, error) {
+	if cfg.UnixSocket == "" {
+		return net.Listen("tcp", cfg.Addr)
+	}
+
+	// A socket file left over from a previous (unclean) run would prevent us
+	// from binding, so remove it first. We refuse to remove anything that
+	// isn't a socket.
+	if info, err := os.Stat(cfg.UnixSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.UnixSocket)
+		}
+		if err := os.Remove(cfg.UnixSocket); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", cfg.UnixSocket)
+}
+
+func generateSelfSignedCert() (tls.Certificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"reddit_viewer development"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Keyboard shortcuts for the feed pages:
+//
+//   j / k  select the next / previous post
+//   o      open the selected post's link
+//   c      open the selected post's comments
+//   n / p  go to the next / previous page
+//
+// Posts are the elements marked with data-post (see the "post-attrs"
+// template). Selecting a post focuses it, so the browser's focus styles and
+// screen readers follow along.
+(function () {
+    "use strict";
+
+    const posts = Array.from(document.querySelectorAll("[data-post]"));
+    const reducedMotion = window.matchMedia("(prefers-reduced-motion: reduce)");
+    let selected = -1;
+
+    function select(index) {
+        if (posts.length === 0) {
+            return;
+        }
+        selected = Math.max(0, Math.min(index, posts.length - 1));
+
+        const post = posts[selected];
+        post.focus({ preventScroll: true });
+        post.scrollIntoView({
+            block: "start",
+            behavior: reducedMotion.matches ? "auto" : "smooth",
+        });
+    }
+
+    function open(url) {
+        if (url) {
+            window.location.href = url;
+        }
+    }
+
+    function follow(rel) {
+        const link = document.querySelector("a[rel~='" + rel + "']");
+        if (link !== null) {
+            link.click();
+        }
+    }
+
+    function isTyping(target) {
+        if (target.tagName === "INPUT") {
+            // e.g. the checkboxes that reveal blurred media
+            return !["checkbox", "radio", "button", "submit"].includes(target.type);
+        }
+        return target.isContentEditable ||
+            ["TEXTAREA", "SELECT"].includes(target.tagName);
+    }
+
+    // Keep the selection in sync when a post is focused some other way (e.g.
+    // clicked, or reached with Tab in the gallery layout).
+    document.addEventListener("focusin", function (event) {
+        const post = event.target.closest("[data-post]");
+        if (post !== null) {
+            selected = posts.indexOf(post);
+        }
+    });
+
+    document.addEventListener("keydown", function (event) {
+        if (event.defaultPrevented || event.altKey || event.ctrlKey ||
+            event.metaKey || isTyping(event.target)) {
+            return;
+        }
+
+        const post = posts[selected];
+        switch (event.key) {
+            case "j":
+                select(selected + 1);
+                break;
+            case "k":
+                select(selected - 1);
+                break;
+            case "o":
+                if (post === undefined) {
+                    return;
+                }
+                open(post.dataset.postLink);
+                break;
+            case "c":
+                if (post === undefined) {
+                    return;
+                }
+                open(post.dataset.commentsLink);
+                break;
+            case "n":
+                follow("next");
+                break;
+            case "p":
+                follow("prev");
+                break;
+            default:
+                return;
+        }
+        event.preventDefault();
+    });
+})();
@@ -0,0 +1,77 @@
+// Read tracking for the feed pages. A post counts as seen once it has been
+// scrolled past (its bottom edge leaves the top of the screen), or once it's
+// opened with a click or the "o" and "c" shortcuts.
+//
+// Seen posts are reported to the server (see SeenHandler) in batches, and
+// right away before leaving the page, so the next feed can mark or hide them.
+(function () {
+    "use strict";
+
+    const flushInterval = 10000;
+    const pending = new Set();
+    const reported = new Set();
+
+    function mark(post) {
+        const id = post.dataset.postId;
+        if (id && !reported.has(id)) {
+            pending.add(id);
+        }
+    }
+
+    function flush() {
+        if (pending.size === 0) {
+            return;
+        }
+        const body = new URLSearchParams();
+        pending.forEach(function (id) {
+            body.append("id", id);
+            reported.add(id);
+        });
+        pending.clear();
+
+        // Beacons outlive the page, unlike fetch requests
+        navigator.sendBeacon("/api/seen", body);
+    }
+
+    const observer = new IntersectionObserver(function (entries) {
+        entries.forEach(function (entry) {
+            if (!entry.isIntersecting && entry.boundingClientRect.bottom <= 0) {
+                mark(entry.target);
+                observer.unobserve(entry.target);
+            }
+        });
+    });
+    document.querySelectorAll("[data-post-id]").forEach(function (post) {
+        observer.observe(post);
+    });
+
+    document.addEventListener("click", function (event) {
+        const link = event.target.closest("a");
+        const post = link === null ? null : link.closest("[data-post-id]");
+        if (post !== null) {
+            mark(post);
+            flush();
+        }
+    });
+
+    // keyboard.js focuses the selected post, so that's the one being opened
+    document.addEventListener("keydown", function (event) {
+        if (event.altKey || event.ctrlKey || event.metaKey ||
+            (event.key !== "o" && event.key !== "c")) {
+            return;
+        }
+        const post = document.activeElement.closest("[data-post-id]");
+        if (post !== null) {
+            mark(post);
+            flush();
+        }
+    });
+
+    document.addEventListener("visibilitychange", function () {
+        if (document.visibilityState === "hidden") {
+            flush();
+        }
+    });
+    window.addEventListener("pagehide", flush);
+    window.setInterval(flush, flushInterval);
+})();
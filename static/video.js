@@ -0,0 +1,64 @@
+// Upgrades Reddit videos to DASH playback where the browser supports it.
+//
+// Each <video data-dash-src="..."> already lists HLS and progressive MP4
+// <source> elements, so videos remain playable if this script (or dash.js)
+// fails to load. dash.js is only downloaded when a page actually needs it.
+//
+// Animated images (<video data-autoplay>) are played while they're on screen,
+// unless the user prefers reduced motion. Otherwise they behave like any
+// other video, and only play when asked to.
+(function () {
+    "use strict";
+
+    const DASH_SCRIPT = "/static/v4.7.1_dash.all.min.js";
+    let dashLoader = null;
+
+    function loadDash() {
+        if (dashLoader === null) {
+            dashLoader = new Promise(function (resolve, reject) {
+                const script = document.createElement("script");
+                script.src = DASH_SCRIPT;
+                script.onload = function () { resolve(window.dashjs); };
+                script.onerror = reject;
+                document.head.appendChild(script);
+            });
+        }
+        return dashLoader;
+    }
+
+    function upgrade(video) {
+        // DASH requires Media Source Extensions. Browsers without them
+        // (e.g. Safari on iPhone) play the HLS <source> natively instead.
+        if (!("MediaSource" in window)) {
+            return;
+        }
+
+        loadDash().then(function (dashjs) {
+            const player = dashjs.MediaPlayer().create();
+            player.initialize(video, video.dataset.dashSrc, false);
+        }).catch(function () {
+            // Keep playing the native sources
+        });
+    }
+
+    document.querySelectorAll("video[data-dash-src]").forEach(upgrade);
+
+    const reducedMotion = window.matchMedia("(prefers-reduced-motion: reduce)");
+    const animated = document.querySelectorAll("video[data-autoplay]");
+    if (reducedMotion.matches || animated.length === 0 || !("IntersectionObserver" in window)) {
+        return;
+    }
+
+    const observer = new IntersectionObserver(function (entries) {
+        entries.forEach(function (entry) {
+            if (entry.isIntersecting) {
+                entry.target.play().catch(function () {
+                    // Blocked by the browser's own autoplay policy
+                });
+            } else {
+                entry.target.pause();
+            }
+        });
+    });
+    animated.forEach(function (video) { observer.observe(video); });
+})();
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findSubreddit parses old Reddit's side bar into a Subreddit. The side bar
+// looks roughly like this:
+//
+//	<div class="side">
+//	  <div class="spacer">
+//	    <div class="titlebox">
+//	      <h1 class="hover redditname"><a href="...">[NAME]</a></h1>
+//	      <span class="subscribers"><span class="number">1,234</span> readers</span>
+//	      <p class="users-online"><span class="number">56</span> users here now</p>
+//	      <div class="usertext-body md-container"><div class="md">[DESCRIPTION]</div></div>
+//	      <div class="bottom">
+//	        <span class="age">a community for <time datetime="[RFC3339]">...</time></span>
+//	      </div>
+//	    </div>
+//	  </div>
+//	  <div class="spacer">
+//	    <div class="sidecontentbox">
+//	      <div class="title"><h1>MODERATORS</h1></div>
+//	      <ul class="content">
+//	        <li><a class="author" href="...">[MODERATOR]</a></li>
+//	        ...
+//	      </ul>
+//	    </div>
+//	  </div>
+//	</div>
+func findSubreddit(doc *html.Node) (*Subreddit, error) {
+
+	side, err := BreadthFirstSearch(doc,
+		And(
+			IsTag(atom.Div),
+			HasClass("side"),
+		),
+		Not(IsTag(atom.Head)),
+	)
+	if err != nil {
+		return nil, ErrSubredditInfoNotFound
+	}
+
+	titleBox, err := BreadthFirstSearch(side,
+		And(
+			IsTag(atom.Div),
+			HasClass("titlebox"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrSubredditInfoNotFound
+	}
+
+	subreddit := &Subreddit{}
+
+	// Name
+	if nameNode, err := BreadthFirstSearch(titleBox, HasClass("redditname"), RecurseAlways); err == nil {
+		subreddit.Name = strings.TrimSpace(TextContent(nameNode))
+	}
+	if subreddit.Name == "" {
+		return nil, ErrSubredditInfoNotFound
+	}
+
+	// Title. The side bar only shows the name, but the page title is the
+	// subreddit's title.
+	if titleNode, err := BreadthFirstSearch(doc, IsTag(atom.Title), Not(IsTag(atom.Body))); err == nil {
+		subreddit.Title = strings.TrimSpace(TextContent(titleNode))
+	}
+
+	// Subscriber and active user counts
+	subreddit.Subscribers = findSideBarNumber(titleBox, "subscribers")
+	subreddit.ActiveUsers = findSideBarNumber(titleBox, "users-online")
+
+	// Description
+	if body, err := BreadthFirstSearch(titleBox,
+		And(
+			IsTag(atom.Div),
+			HasClass("md"),
+		),
+		RecurseAlways,
+	); err == nil {
+		subreddit.DescriptionHTML = sanitizeHTML(body)
+	}
+
+	// Creation date
+	if age, err := BreadthFirstSearch(titleBox, HasClass("age"), RecurseAlways); err == nil {
+		if timeNode, err := BreadthFirstSearch(age, IsTag(atom.Time), RecurseAlways); err == nil {
+			datetime, _ := GetAttribute(timeNode, "datetime")
+			if created, err := time.Parse(time.RFC3339, datetime); err == nil {
+				subreddit.Created = created.UTC()
+			}
+		}
+	}
+
+	// NSFW communities are flagged on the <body> element
+	if body, err := BreadthFirstSearch(doc, IsTag(atom.Body), RecurseAlways); err == nil {
+		subreddit.IsNSFW = HasClass("over18")(body)
+	}
+
+	subreddit.Moderators = findModerators(side)
+	return subreddit, nil
+}
+
+// findSideBarNumber returns the number inside the element with class
+// 'className', e.g. <span class="subscribers"><span class="number">1,234</span>.
+func findSideBarNumber(titleBox *html.Node, className string) int {
+
+	container, err := BreadthFirstSearch(titleBox, HasClass(className), RecurseAlways)
+	if err != nil {
+		return 0
+	}
+	numberNode, err := BreadthFirstSearch(container, HasClass("number"), RecurseAlways)
+	if err != nil {
+		return 0
+	}
+
+	// Strip separators and approximations (e.g. "~1,234")
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, TextContent(numberNode))
+
+	n, _ := strconv.Atoi(digits)
+	return n
+}
+
+func findModerators(side *html.Node) []string {
+
+	// The moderators box is the 'sidecontentbox' whose title mentions them
+	var box *html.Node
+	for _, candidate := range FindAll(side, And(IsTag(atom.Div), HasClass("sidecontentbox")), RecurseAlways) {
+		if strings.Contains(strings.ToLower(TextContent(candidate)), "moderators") {
+			box = candidate
+			break
+		}
+	}
+	if box == nil {
+		return nil
+	}
+
+	var moderators []string
+	for _, link := range FindAll(box, And(IsTag(atom.A), HasClass("author")), RecurseAlways) {
+		if name := strings.TrimSpace(TextContent(link)); name != "" {
+			moderators = append(moderators, name)
+		}
+	}
+	return moderators
+}
+
+// ------------------------------------------------------------------------- //
+// Rules
+// ------------------------------------------------------------------------- //
+
+// fillSubredditRules downloads the rules page of 'subreddit'. Old Reddit
+// doesn't include the rules in the side bar, so this costs an extra request.
+// Failures are logged and otherwise ignored, since the rest of the side bar is
+// still usable without them.
+func (rp *RedditParser) fillSubredditRules(
+	ctx context.Context,
+	subreddit *Subreddit,
+	opts *feedOpts,
+) {
+	getURL := fmt.Sprintf("%s/r/%s/about/rules", opts.BaseURL, url.PathEscape(*opts.Subreddit))
+	logF(LevelTrace, "Issuing request: GET %s", getURL)
+
+	doc, err := rp.getFeedDocument(ctx, getURL, opts.Headers)
+	if err != nil {
+		logF(LevelWarning, "Failed to fetch rules for r/%s: %v", subreddit.Name, err)
+		return
+	}
+	subreddit.Rules = findSubredditRules(doc)
+}
+
+// findSubredditRules parses the rules page:
+//
+//	<div class="subreddit-rules-page">
+//	  <div class="subreddit-rule-item">
+//	    <p class="subreddit-rule-title">[TITLE]</p>
+//	    <div class="subreddit-rule-description md">[DESCRIPTION]</div>
+//	  </div>
+//	  ...
+//	</div>
+func findSubredditRules(doc *html.Node) []SubredditRule {
+
+	var rules []SubredditRule
+	for _, item := range FindAll(doc, HasClass("subreddit-rule-item"), Not(IsTag(atom.Head))) {
+		var rule SubredditRule
+		if title, err := BreadthFirstSearch(item, HasClass("subreddit-rule-title"), RecurseAlways); err == nil {
+			rule.Title = strings.TrimSpace(TextContent(title))
+		}
+		if description, err := BreadthFirstSearch(item, HasClass("subreddit-rule-description"), RecurseAlways); err == nil {
+			rule.DescriptionHTML = sanitizeHTML(description)
+		}
+		if rule.Title != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	subscriptionsFileName = "subscriptions.json"
+
+	homePath          = "/home"
+	subscriptionsPath = "/subscriptions"
+
+	maxOPMLBytes       = 1 << 20
+	maxAPIRequestBytes = 64 << 10
+
+	// opmlBaseURL is used for the links in exported OPML files, which are
+	// meant for other feed readers rather than for this server.
+	opmlBaseURL = "https://www.reddit.com"
+)
+
+var (
+	ErrInvalidSubredditName = errors.New("invalid subreddit name")
+	ErrNotSubscribed        = errors.New("not subscribed to that subreddit")
+	ErrInvalidOPML          = errors.New("invalid OPML document")
+)
+
+// subscriptionNameRegex matches a single subreddit name. Reddit allows 3 to 21
+// characters, but a few older subreddits have 2 letter names.
+var subscriptionNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_]{1,20}$`)
+
+// Subscription is a subreddit the user follows locally, without a Reddit
+// account.
+type Subscription struct {
+	Name  string    `json:"name"`
+	Added time.Time `json:"added"`
+}
+
+// SubscriptionStore keeps the user's subscriptions in a JSON file in the data
+// directory. It's safe for concurrent use.
+type SubscriptionStore struct {
+	path string
+	now  func() time.Time
+
+	mu            sync.Mutex
+	subscriptions []Subscription // sorted by name
+}
+
+// subscriptionList is the format of the subscriptions file, and of the API's
+// responses.
+type subscriptionList struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// OpenSubscriptionStore loads the subscriptions saved in 'dataDir', if any.
+func OpenSubscriptionStore(dataDir string) (*SubscriptionStore, error) {
+	ss := &SubscriptionStore{
+		path: filepath.Join(dataDir, subscriptionsFileName),
+		now:  time.Now,
+	}
+
+	var file subscriptionList
+	if err := readJSONFile(ss.path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ss.path, err)
+	}
+	ss.subscriptions = file.Subscriptions
+	slices.SortFunc(ss.subscriptions, compareSubscriptions)
+	return ss, nil
+}
+
+// List returns the subscriptions, sorted by name. The slice is never nil.
+func (ss *SubscriptionStore) List() []Subscription {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return append([]Subscription{}, ss.subscriptions...)
+}
+
+// Multireddit returns every subscribed subreddit joined with '+' (e.g.
+// "golang+rust"), which Reddit serves as a single merged feed. It returns
+// false if there are no subscriptions.
+func (ss *SubscriptionStore) Multireddit() (string, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.subscriptions) == 0 {
+		return "", false
+	}
+	names := make([]string, len(ss.subscriptions))
+	for i, s := range ss.subscriptions {
+		names[i] = s.Name
+	}
+	return strings.Join(names, "+"), true
+}
+
+// Add subscribes to each of 'names', ignoring the ones that are already
+// subscribed. Names may be given with or without the "r/" prefix. Nothing is
+// added if any name is invalid.
+func (ss *SubscriptionStore) Add(names ...string) error {
+
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name, err := normalizeSubredditName(name)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, name)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	added := ss.now().UTC().Truncate(time.Second)
+	subscriptions := slices.Clone(ss.subscriptions)
+	for _, name := range normalized {
+		if !slices.ContainsFunc(subscriptions, func(s Subscription) bool { return s.Name == name }) {
+			subscriptions = append(subscriptions, Subscription{Name: name, Added: added})
+		}
+	}
+	slices.SortFunc(subscriptions, compareSubscriptions)
+	return ss.save(subscriptions)
+}
+
+// Remove unsubscribes from 'name'.
+func (ss *SubscriptionStore) Remove(name string) error {
+
+	name, err := normalizeSubredditName(name)
+	if err != nil {
+		return err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	i := slices.IndexFunc(ss.subscriptions, func(s Subscription) bool { return s.Name == name })
+	if i < 0 {
+		return ErrNotSubscribed
+	}
+	return ss.save(slices.Delete(slices.Clone(ss.subscriptions), i, i+1))
+}
+
+// save writes 'subscriptions' to disk, and only then makes them current, so
+// a failed write doesn't leave the store and the file out of sync. The caller
+// must hold ss.mu.
+func (ss *SubscriptionStore) save(subscriptions []Subscription) error {
+	if err := writeJSONFile(ss.path, subscriptionList{Subscriptions: subscriptions}); err != nil {
+		return fmt.Errorf("failed to save subscriptions: %w", err)
+	}
+	ss.subscriptions = subscriptions
+	return nil
+}
+
+func compareSubscriptions(a, b Subscription) int {
+	return strings.Compare(a.Name, b.Name)
+}
+
+// normalizeSubredditName validates a subreddit name, stripping an optional
+// "r/" or "/r/" prefix and trailing slash. Names are stored in lower case,
+// since Reddit treats them case insensitively.
+func normalizeSubredditName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	name = strings.TrimPrefix(name, "/")
+	if len(name) > 2 && strings.EqualFold(name[:2], "r/") {
+		name = name[2:]
+	}
+	name = strings.TrimSuffix(name, "/")
+	if !subscriptionNameRegex.MatchString(name) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidSubredditName, name)
+	}
+	return strings.ToLower(name), nil
+}
+
+// splitList splits user input on commas and white space, e.g. "a, b c".
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+}
+
+// ------------------------------------------------------------------------- //
+// OPML
+// ------------------------------------------------------------------------- //
+
+// OPML is the usual format for moving feed subscriptions between readers.
+// Each subscription is exported as an outline pointing at the subreddit's RSS
+// feed, so the file can be imported by other feed readers as well.
+//
+//	<opml version="2.0">
+//	  <head><title>Reddit subscriptions</title></head>
+//	  <body>
+//	    <outline type="rss" text="r/golang" title="r/golang"
+//	             xmlUrl="https://www.reddit.com/r/golang/.rss"
+//	             htmlUrl="https://www.reddit.com/r/golang/" />
+//	  </body>
+//	</opml>
+
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated,omitempty"`
+	Outline []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Type     string        `xml:"type,attr,omitempty"`
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Children []opmlOutline `xml:"outline"`
+}
+
+// writeOPML exports 'subscriptions' as an OPML document.
+func writeOPML(w io.Writer, subscriptions []Subscription, created time.Time) error {
+
+	doc := opmlDocument{
+		Version: "2.0",
+		Title:   "Reddit subscriptions",
+		Created: created.UTC().Format(time.RFC1123Z),
+	}
+	for _, s := range subscriptions {
+		feedURL := opmlBaseURL + feedRoute{Subreddit: &s.Name}.Path()
+		doc.Outline = append(doc.Outline, opmlOutline{
+			Type:    "rss",
+			Text:    "r/" + s.Name,
+			Title:   "r/" + s.Name,
+			XMLURL:  feedURL + ".rss",
+			HTMLURL: feedURL,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// readOPML returns the subreddits listed in an OPML document. Outlines may be
+// nested in folders, and any outline that doesn't refer to a subreddit (e.g.
+// a blog's feed in a file exported from another reader) is skipped.
+func readOPML(r io.Reader) ([]string, error) {
+
+	var doc opmlDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOPML, err)
+	}
+
+	var names []string
+	var visit func(outlines []opmlOutline)
+	visit = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			if name, ok := opmlSubreddit(o); ok && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+			visit(o.Children)
+		}
+	}
+	visit(doc.Outline)
+	return names, nil
+}
+
+// opmlSubreddit finds the subreddit an outline refers to, from its feed URL,
+// its web URL or, failing that, its text (e.g. "r/golang").
+func opmlSubreddit(o opmlOutline) (string, bool) {
+	for _, link := range []string{o.XMLURL, o.HTMLURL} {
+		u, err := url.Parse(link)
+		if err != nil || !hostIs(u, "reddit.com") {
+			continue
+		}
+		segments := splitPath(u.Path)
+		if len(segments) >= 2 && segments[0] == "r" {
+			name := strings.TrimSuffix(segments[1], ".rss")
+			if name, err := normalizeSubredditName(name); err == nil {
+				return name, true
+			}
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(o.Text), "r/") {
+		if name, err := normalizeSubredditName(o.Text); err == nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ------------------------------------------------------------------------- //
+// Handlers
+// ------------------------------------------------------------------------- //
+
+// HomeHandler serves the merged feed of every subscribed subreddit. Reddit does
+// the merging, since the subscriptions are requested as a multireddit. Paths
+// mirror the front page's, and take the same paging parameters and ".json"
+// suffix:
+//
+//	[root]/home/
+//	[root]/home/[sort_method]/
+//	[root]/home/[sort_method]/?after=[last_post_id]&count=[count]
+//
+// Without any subscriptions, browsers are sent to the subscriptions page and
+// JSON clients get an empty feed.
+type HomeHandler struct {
+	Feeds         *ProxyHandler
+	Subscriptions *SubscriptionStore
+}
+
+func (hh *HomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	retryLink := r.URL.RequestURI()
+	outputJSON := trimJSONSuffix(r)
+
+	// Only the front page's routes (i.e. a sort method) are valid here
+	route, err := matchFeedRoute(strings.TrimPrefix(r.URL.Path, homePath))
+	if err == nil && route.Subreddit != nil {
+		err = ErrRouteNotFound
+	}
+	if err != nil {
+		writeError(w, r, hh.Feeds.Templates, outputJSON, describeError(err))
+		return
+	}
+
+	if canonical := homePath + route.Path(); !outputJSON && r.URL.Path != canonical &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		target := canonical
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	multireddit, ok := hh.Subscriptions.Multireddit()
+	if !ok {
+		if outputJSON {
+			writeJSON(w, r, hh.Feeds.Templates, &Feed{Posts: []FeedPost{}})
+			return
+		}
+		http.Redirect(w, r, subscriptionsPath, http.StatusSeeOther)
+		return
+	}
+
+	// The parser's paging links point at the multireddit, so they're moved
+	// back under /home
+	upstream := route
+	upstream.Subreddit = &multireddit
+	hh.Feeds.serveFeed(w, r, upstream, outputJSON, retryLink, func(feed *Feed) {
+		feed.NextPageLink = homeLink(feed.NextPageLink, upstream, route)
+		feed.PrevPageLink = homeLink(feed.PrevPageLink, upstream, route)
+	})
+}
+
+// homeLink rewrites 'link', a local link to the multireddit feed 'upstream',
+// into the matching link to the home feed 'home'.
+func homeLink(link string, upstream feedRoute, home feedRoute) string {
+	if rest, ok := strings.CutPrefix(link, upstream.Path()); ok {
+		return homePath + home.Path() + rest
+	}
+	return link
+}
+
+// SubscriptionsHandler manages the subscriptions, either from a page in the
+// browser or through a JSON API:
+//
+//	GET    [root]/subscriptions
+//	POST   [root]/subscriptions                   name=[subreddits]
+//	POST   [root]/subscriptions/remove            name=[subreddit]
+//	POST   [root]/subscriptions/import            opml=[file]
+//	GET    [root]/subscriptions.opml
+//	GET    [root]/api/subscriptions
+//	POST   [root]/api/subscriptions               {"name": "[subreddit]"}
+//	DELETE [root]/api/subscriptions/[subreddit]
+//
+// The add form accepts several names, separated by spaces or commas. Forms
+// redirect back to the page once they're handled, or show it again with an
+// error.
+type SubscriptionsHandler struct {
+	Store     *SubscriptionStore
+	Templates *TemplateRegistry
+}
+
+// Register adds the handler's routes to 'mux', wrapping each with 'wrap' (e.g.
+// loggingHandler).
+func (sh *SubscriptionsHandler) Register(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	handle := func(pattern string, f http.HandlerFunc) {
+		mux.Handle(pattern, wrap(f))
+	}
+	guarded := func(pattern string, f http.HandlerFunc) {
+		mux.Handle(pattern, wrap(sameOriginHandler(sh.Templates, f)))
+	}
+	handle("GET "+subscriptionsPath, sh.servePage)
+	guarded("POST "+subscriptionsPath, sh.serveAdd)
+	guarded("POST "+subscriptionsPath+"/remove", sh.serveRemove)
+	guarded("POST "+subscriptionsPath+"/import", sh.serveImport)
+	handle("GET "+subscriptionsPath+".opml", sh.serveExport)
+	handle("GET /api/subscriptions", sh.serveAPIList)
+	guarded("POST /api/subscriptions", sh.serveAPIAdd)
+	guarded("DELETE /api/subscriptions/{name}", sh.serveAPIRemove)
+}
+
+func (sh *SubscriptionsHandler) servePage(w http.ResponseWriter, r *http.Request) {
+	sh.renderPage(w, r, nil)
+}
+
+func (sh *SubscriptionsHandler) serveAdd(w http.ResponseWriter, r *http.Request) {
+	names := splitList(r.PostFormValue("name"))
+	if len(names) == 0 {
+		sh.renderPage(w, r, ErrInvalidSubredditName)
+		return
+	}
+	sh.afterForm(w, r, sh.Store.Add(names...))
+}
+
+func (sh *SubscriptionsHandler) serveRemove(w http.ResponseWriter, r *http.Request) {
+	sh.afterForm(w, r, sh.Store.Remove(r.PostFormValue("name")))
+}
+
+func (sh *SubscriptionsHandler) serveImport(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOPMLBytes)
+	file, _, err := r.FormFile("opml")
+	if err != nil {
+		sh.renderPage(w, r, fmt.Errorf("%w: %v", ErrBadRequest, err))
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	names, err := readOPML(file)
+	if err != nil {
+		sh.renderPage(w, r, err)
+		return
+	}
+	sh.afterForm(w, r, sh.Store.Add(names...))
+}
+
+func (sh *SubscriptionsHandler) serveExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+	if err := writeOPML(w, sh.Store.List(), time.Now()); err != nil {
+		logF(LevelDebug, "Failed to write OPML: %v", err)
+	}
+}
+
+// afterForm redirects back to the subscriptions page after a successful form
+// submission, or shows the page again with 'err'.
+func (sh *SubscriptionsHandler) afterForm(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		sh.renderPage(w, r, err)
+		return
+	}
+	http.Redirect(w, r, subscriptionsPath, http.StatusSeeOther)
+}
+
+// renderPage renders the subscriptions page, with 'err' (if any) shown above
+// the list.
+func (sh *SubscriptionsHandler) renderPage(w http.ResponseWriter, r *http.Request, err error) {
+
+	prefs := readPreferences(r, sh.Templates)
+	page := SubscriptionsPage{Subscriptions: sh.Store.List(), Prefs: prefs}
+	statusCode := http.StatusOK
+	if err != nil {
+		info := sh.Templates.translateError(describeError(err), prefs.Locale)
+		if info.StatusCode >= http.StatusInternalServerError {
+			logF(LevelError, "Failed to update subscriptions: %v", err)
+		}
+		page.Error = &info
+		statusCode = info.StatusCode
+	}
+
+	out, err := sh.Templates.Render(prefs.Locale, "subscriptions.html", page)
+	if err != nil {
+		logF(LevelError, "Failed to render subscriptions: %v", err)
+		writeError(w, r, sh.Templates, false, describeError(err))
+		return
+	}
+	savePreferences(w, r, prefs)
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(out)
+}
+
+func (sh *SubscriptionsHandler) serveAPIList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, sh.Templates, subscriptionList{Subscriptions: sh.Store.List()})
+}
+
+func (sh *SubscriptionsHandler) serveAPIAdd(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestBytes)).Decode(&body)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrBadRequest, err)
+	} else {
+		err = sh.Store.Add(body.Name)
+	}
+	sh.afterAPI(w, r, err)
+}
+
+func (sh *SubscriptionsHandler) serveAPIRemove(w http.ResponseWriter, r *http.Request) {
+	sh.afterAPI(w, r, sh.Store.Remove(r.PathValue("name")))
+}
+
+// afterAPI responds to an API request with the updated subscriptions, or with
+// 'err'.
+func (sh *SubscriptionsHandler) afterAPI(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		info := describeError(err)
+		if info.StatusCode >= http.StatusInternalServerError {
+			logF(LevelError, "Failed to update subscriptions: %v", err)
+		}
+		writeError(w, r, sh.Templates, true, info)
+		return
+	}
+	sh.serveAPIList(w, r)
+}
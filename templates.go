@@ -2,32 +2,162 @@ package main
 
 import (
 	"bytes"
-	"embed"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"sync"
 	"time"
 )
 
-// NOTE: Our assets are embedded in the binary to ensure that they are always
-// available, regardless of which directory the application is running in. This
-// also helps to simplify testing, since the embedded files are accessible at
-// both test time and run time.
+const (
+	// aboveFoldPosts is how many posts are assumed to be visible before the
+	// user scrolls. Images further down the page are loaded lazily.
+	aboveFoldPosts = 2
+)
+
+// TemplateRegistry holds the parsed page templates. Each file directly inside
+// "templates/" is a page, and any files in "templates/partials/" are parsed
+// alongside every page so they can share definitions.
 //
-//go:embed templates
-var templates embed.FS
+// Templates are parsed once, up front, and are safe for concurrent use. Each
+// page is parsed once per locale, with template functions (see templateFuncs)
+// bound to that locale. Reload re-parses them from the same file system, which
+// is used by dev mode to pick up changes without a restart.
+type TemplateRegistry struct {
+	fsys     fs.FS
+	messages *Catalog
+	time     *TimeFormatter
+
+	mu    sync.RWMutex
+	pages map[string]map[string]*template.Template // locale -> name -> page
+}
 
-func renderFeed(feed *Feed) ([]byte, error) {
+// NewTemplateRegistry parses the templates in 'fsys', which should contain the
+// "templates" and "static" directories (e.g. the embedded assets, or
+// os.DirFS(".")). Text is translated with 'messages', and timestamps are
+// formatted with 'timeFormatter'.
+func NewTemplateRegistry(
+	fsys fs.FS,
+	messages *Catalog,
+	timeFormatter *TimeFormatter,
+) (*TemplateRegistry, error) {
+	tr := &TemplateRegistry{fsys: fsys, messages: messages, time: timeFormatter}
+	if err := tr.Reload(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
 
-	tmpl, err := template.New("feed.html").Funcs(template.FuncMap{
-		"formatTime": formatTimeSincePost,
-		"typeString": typeString,
-	}).ParseFS(templates, "templates/feed.html")
+// Reload re-parses every page, and prepares a copy of each for every locale in
+// the catalog. If any page fails to parse, the previously loaded templates
+// are kept and the error is returned.
+func (tr *TemplateRegistry) Reload() error {
+
+	pagePaths, err := fs.Glob(tr.fsys, "templates/*.html")
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(pagePaths) == 0 {
+		return fmt.Errorf("no templates found")
+	}
+	partialPaths, err := fs.Glob(tr.fsys, "templates/partials/*.html")
+	if err != nil {
+		return err
+	}
+
+	// Each page is parsed once, and then cloned for every locale with that
+	// locale's helpers. The default locale's helpers are only placeholders
+	// for parsing, since the parsed templates are never executed themselves.
+	parsed := make(map[string]*template.Template, len(pagePaths))
+	for _, pagePath := range pagePaths {
+		name := path.Base(pagePath)
+		tmpl, err := template.New(name).
+			Funcs(tr.templateFuncs(defaultLocale)).
+			ParseFS(tr.fsys, append([]string{pagePath}, partialPaths...)...)
+		if err != nil {
+			return err
+		}
+		parsed[name] = tmpl
+	}
+
+	pages := map[string]map[string]*template.Template{}
+	for _, locale := range tr.messages.Locales() {
+		pages[locale] = make(map[string]*template.Template, len(parsed))
+		for name, tmpl := range parsed {
+			clone, err := tmpl.Clone()
+			if err != nil {
+				return err
+			}
+			pages[locale][name] = clone.Funcs(tr.templateFuncs(locale))
+		}
+	}
+
+	tr.mu.Lock()
+	tr.pages = pages
+	tr.mu.Unlock()
+	return nil
+}
+
+// templateFuncs returns the helpers available to every page in 'locale'.
+func (tr *TemplateRegistry) templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"typeString": typeString,
+		"t": func(key string, args ...any) string {
+			return tr.messages.Translate(locale, key, args...)
+		},
+		"relativeTime": func(t time.Time) string {
+			return tr.time.Relative(locale, t)
+		},
+		"absoluteTime": func(t time.Time) string {
+			return tr.time.Absolute(locale, t)
+		},
+		"formatDate": func(t time.Time) string {
+			return tr.time.Date(locale, t)
+		},
+		"isoTime": func(t time.Time) string {
+			return t.UTC().Format(time.RFC3339)
+		},
+		"srcset":           srcset,
+		"largestImage":     largestImage,
+		"responsiveImages": responsiveImages,
+		"mediaLabel": func(post FeedPost) string {
+			return tr.mediaLabel(locale, post)
+		},
+	}
+}
+
+// mediaLabel describes a post's media for screen readers (e.g. as the alt text
+// of its image), based on the kind of post and its title.
+func (tr *TemplateRegistry) mediaLabel(locale string, post FeedPost) string {
+	title := post.Title
+	if title == "" {
+		title = post.Domain
+	}
+	if label, ok := tr.messages.Lookup(locale, "post.alt."+post.Type.String(), title); ok {
+		return label
+	}
+	return tr.messages.Translate(locale, "post.alt.media", title)
+}
+
+// Render executes the page called 'name' (e.g. "feed.html") in 'locale' with
+// 'data'. Unknown locales fall back to the default locale.
+func (tr *TemplateRegistry) Render(locale string, name string, data any) ([]byte, error) {
+
+	tr.mu.RLock()
+	localePages, ok := tr.pages[locale]
+	if !ok {
+		localePages = tr.pages[defaultLocale]
+	}
+	tmpl, ok := localePages[name]
+	tr.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("template %q not found", name)
 	}
 
 	out := &bytes.Buffer{}
-	err = tmpl.Execute(out, feed)
+	err := tmpl.Execute(out, data)
 	if err != nil {
 		return nil, err
 	}
@@ -35,51 +165,77 @@ func renderFeed(feed *Feed) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
-func formatTimeSincePost(timestamp time.Time) string {
+// Themes lists the themes that can be selected with Preferences.Theme.
+func (tr *TemplateRegistry) Themes() []string {
+	return findThemes(tr.fsys)
+}
 
-	const (
-		SecondsPerMinute = 60
-		SecondsPerHour   = 60 * SecondsPerMinute
-		SecondsPerDay    = 24 * SecondsPerHour
-		SecondsPerMonth  = 30 * SecondsPerDay
-		SecondsPerYear   = 365 * SecondsPerDay
-	)
+// Locales lists the locales that can be selected with Preferences.Locale.
+func (tr *TemplateRegistry) Locales() []string {
+	return tr.messages.Locales()
+}
 
-	pluralize := func(s string, val int) string {
-		if val == 1 {
-			return s
-		}
-		return s + "s"
-	}
+// FeedPage is the data passed to the feed template. The Feed is embedded so
+// the template can refer to its fields directly (e.g. ".Posts").
+type FeedPage struct {
+	*Feed
+	Prefs Preferences
 
-	seconds := int(time.Now().UTC().Sub(timestamp).Seconds())
+	// TrackSeen enables the script that reports seen posts to SeenHandler.
+	TrackSeen bool
 
-	interval := seconds / SecondsPerYear
-	if interval >= 1 {
-		return fmt.Sprintf("%d %s", interval, pluralize("year", interval))
-	}
+	// SavedView is set on the saved posts page (see SavedHandler).
+	SavedView *SavedView
+}
 
-	interval = seconds / SecondsPerMonth
-	if interval >= 1 {
-		return fmt.Sprintf("%d %s", interval, pluralize("month", interval))
-	}
+// ErrorPage is the data passed to the error template.
+type ErrorPage struct {
+	ErrorInfo
+	Prefs Preferences
+}
 
-	interval = seconds / SecondsPerDay
-	if interval >= 1 {
-		return fmt.Sprintf("%d %s", interval, pluralize("day", interval))
-	}
+// SubscriptionsPage is the data passed to the subscriptions template. Error is
+// set when a form submission failed.
+type SubscriptionsPage struct {
+	Subscriptions []Subscription
+	Error         *ErrorInfo
+	Prefs         Preferences
+}
 
-	interval = seconds / SecondsPerHour
-	if interval >= 1 {
-		return fmt.Sprintf("%d %s", interval, pluralize("hour", interval))
-	}
+// renderFeed renders 'page' using the template for the preferred layout.
+func (tr *TemplateRegistry) renderFeed(page FeedPage) ([]byte, error) {
 
-	interval = seconds / SecondsPerMinute
-	if interval >= 1 {
-		return fmt.Sprintf("%d %s", interval, pluralize("minute", interval))
+	// Mark the posts that are likely to be visible without scrolling, on a
+	// copy so the caller's feed is left alone
+	feed := *page.Feed
+	feed.Posts = slices.Clone(feed.Posts)
+	for i := range min(aboveFoldPosts, len(feed.Posts)) {
+		feed.Posts[i].AboveFold = true
 	}
+	page.Feed = &feed
+
+	return tr.Render(page.Prefs.Locale, page.Prefs.Layout.TemplateName(), page)
+}
+
+// renderError renders 'info', translating its title and message when the
+// catalog has them.
+func (tr *TemplateRegistry) renderError(info ErrorInfo, prefs Preferences) ([]byte, error) {
+	info = tr.translateError(info, prefs.Locale)
+	return tr.Render(prefs.Locale, "error.html", ErrorPage{ErrorInfo: info, Prefs: prefs})
+}
 
-	return fmt.Sprintf("%d %s", interval, pluralize("second", interval))
+// translateError replaces the title and message of 'info' with their
+// translations in 'locale', when the catalog has them.
+func (tr *TemplateRegistry) translateError(info ErrorInfo, locale string) ErrorInfo {
+	if title, ok := tr.messages.Lookup(locale, fmt.Sprintf("status.%d", info.StatusCode)); ok {
+		info.Title = title
+	}
+	if info.MessageKey != "" {
+		if message, ok := tr.messages.Lookup(locale, info.MessageKey, info.MessageArgs...); ok {
+			info.Message = message
+		}
+	}
+	return info
 }
 
 func typeString(t FeedPostType) string {
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"time"
+)
+
+// Clock tells the time. It's an interface so that relative times can be
+// computed against a fixed point in time (e.g. in tests).
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to the Clock interface.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock reports the current wall clock time.
+var SystemClock Clock = ClockFunc(time.Now)
+
+type timeUnit string
+
+// Units are named after their message keys (e.g. "time.ago.minute").
+const (
+	timeUnitSecond timeUnit = "second"
+	timeUnitMinute timeUnit = "minute"
+	timeUnitHour   timeUnit = "hour"
+	timeUnitDay    timeUnit = "day"
+	timeUnitMonth  timeUnit = "month"
+	timeUnitYear   timeUnit = "year"
+)
+
+// ------------------------------------------------------------------------- //
+// Formatting
+// ------------------------------------------------------------------------- //
+
+// TimeFormatter formats post timestamps for display, either relative to the
+// current time ("5 minutes ago") or as absolute timestamps in a fixed time
+// zone. The wording and layouts come from the message catalog.
+type TimeFormatter struct {
+	Messages *Catalog
+
+	// Clock provides the current time for relative timestamps. A nil Clock
+	// means SystemClock.
+	Clock Clock
+
+	// Location is the time zone for absolute timestamps. A nil Location
+	// means UTC.
+	Location *time.Location
+}
+
+// Relative describes how long ago 't' was, e.g. "3 hours ago", in 'locale'.
+// Unknown locales fall back to the default locale.
+func (tf *TimeFormatter) Relative(locale string, t time.Time) string {
+	n, unit := elapsedTime(t, tf.now())
+	return tf.Messages.Translate(locale, "time.ago."+string(unit), n)
+}
+
+// Absolute formats 't' as a full date and time in the formatter's time zone.
+func (tf *TimeFormatter) Absolute(locale string, t time.Time) string {
+	return tf.in(t).Format(tf.Messages.Translate(locale, "time.layout.datetime"))
+}
+
+// Date formats the date of 't' in the formatter's time zone.
+func (tf *TimeFormatter) Date(locale string, t time.Time) string {
+	return tf.in(t).Format(tf.Messages.Translate(locale, "time.layout.date"))
+}
+
+func (tf *TimeFormatter) in(t time.Time) time.Time {
+	if tf.Location == nil {
+		return t.UTC()
+	}
+	return t.In(tf.Location)
+}
+
+func (tf *TimeFormatter) now() time.Time {
+	if tf.Clock == nil {
+		return SystemClock.Now()
+	}
+	return tf.Clock.Now()
+}
+
+// elapsedTime returns the time between 'then' and 'now' in the largest unit
+// that fits at least once. Months and years are counted on the calendar (so
+// Jan 31 to Mar 1 is one month), rather than as a fixed number of days.
+// Times in the future (e.g. due to clock skew) count as no time at all.
+func elapsedTime(then time.Time, now time.Time) (int, timeUnit) {
+
+	d := now.Sub(then)
+	if d < 0 {
+		return 0, timeUnitSecond
+	}
+
+	switch {
+	case d < time.Minute:
+		return int(d / time.Second), timeUnitSecond
+	case d < time.Hour:
+		return int(d / time.Minute), timeUnitMinute
+	case d < 24*time.Hour:
+		return int(d / time.Hour), timeUnitHour
+	}
+
+	// Count whole calendar months, comparing in a single time zone so that
+	// month boundaries line up.
+	then = then.In(now.Location())
+	months := (now.Year()-then.Year())*12 + int(now.Month()-then.Month())
+	if months > 0 && addMonths(then, months).After(now) {
+		months--
+	}
+
+	switch {
+	case months >= 12:
+		return months / 12, timeUnitYear
+	case months >= 1:
+		return months, timeUnitMonth
+	default:
+		return int(d / (24 * time.Hour)), timeUnitDay
+	}
+}
+
+// addMonths adds 'months' to 't', clamping the day to the end of the target
+// month instead of overflowing into the next one (unlike time.AddDate).
+func addMonths(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1,
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	return firstOfMonth.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTimeFormatter(t *testing.T, now time.Time) *TimeFormatter {
+	t.Helper()
+	messages, err := LoadCatalog(embeddedAssets)
+	if err != nil {
+		t.Fatalf("failed to load messages: %v", err)
+	}
+	return &TimeFormatter{
+		Messages: messages,
+		Clock:    ClockFunc(func() time.Time { return now }),
+	}
+}
+
+func TestTimeFormatterRelative(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tf := newTestTimeFormatter(t, now)
+
+	tests := []struct {
+		name string
+		then time.Time
+		en   string
+		de   string
+	}{
+		// Sub-minute
+		{"now", now, "0 seconds ago", "vor 0 Sekunden"},
+		{"one second", now.Add(-time.Second), "1 second ago", "vor 1 Sekunde"},
+		{"seconds", now.Add(-59*time.Second - 999*time.Millisecond), "59 seconds ago", "vor 59 Sekunden"},
+
+		// Singular vs. plural
+		{"one minute", now.Add(-time.Minute), "1 minute ago", "vor 1 Minute"},
+		{"minutes", now.Add(-59 * time.Minute), "59 minutes ago", "vor 59 Minuten"},
+		{"one hour", now.Add(-time.Hour), "1 hour ago", "vor 1 Stunde"},
+		{"hours", now.Add(-23 * time.Hour), "23 hours ago", "vor 23 Stunden"},
+		{"one day", now.Add(-24 * time.Hour), "1 day ago", "vor 1 Tag"},
+		{"days", now.Add(-28 * 24 * time.Hour), "28 days ago", "vor 28 Tagen"},
+
+		// Month boundaries are counted on the calendar: February 2024 has
+		// 29 days, and Jan 31 + 1 month is clamped to Feb 29
+		{"just under a month", time.Date(2024, time.February, 1, 12, 0, 1, 0, time.UTC), "28 days ago", "vor 28 Tagen"},
+		{"one month", time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC), "1 month ago", "vor 1 Monat"},
+		{"end of month", time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC), "1 month ago", "vor 1 Monat"},
+		{"months", time.Date(2023, time.April, 1, 12, 0, 0, 0, time.UTC), "11 months ago", "vor 11 Monaten"},
+
+		// Year boundaries
+		{"just under a year", time.Date(2023, time.March, 1, 12, 0, 1, 0, time.UTC), "11 months ago", "vor 11 Monaten"},
+		{"one year", time.Date(2023, time.March, 1, 12, 0, 0, 0, time.UTC), "1 year ago", "vor 1 Jahr"},
+		{"new year", time.Date(2023, time.December, 31, 12, 0, 0, 0, time.UTC), "2 months ago", "vor 2 Monaten"},
+		{"years", time.Date(2019, time.February, 28, 12, 0, 0, 0, time.UTC), "5 years ago", "vor 5 Jahren"},
+
+		// Future timestamps (e.g. clock skew) count as no time at all
+		{"future", now.Add(time.Hour), "0 seconds ago", "vor 0 Sekunden"},
+		{"far future", now.AddDate(1, 0, 0), "0 seconds ago", "vor 0 Sekunden"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := tf.Relative("en", test.then); got != test.en {
+				t.Errorf("Relative(en) = %q, want %q", got, test.en)
+			}
+			if got := tf.Relative("de", test.then); got != test.de {
+				t.Errorf("Relative(de) = %q, want %q", got, test.de)
+			}
+		})
+	}
+}
+
+func TestTimeFormatterUnknownLocale(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tf := newTestTimeFormatter(t, now)
+
+	if got, want := tf.Relative("xx", now.Add(-2*time.Hour)), "2 hours ago"; got != want {
+		t.Errorf("Relative(xx) = %q, want %q", got, want)
+	}
+}
+
+func TestTimeFormatterAbsolute(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tf := newTestTimeFormatter(t, now)
+	then := time.Date(2024, time.February, 29, 15, 4, 0, 0, time.UTC)
+
+	if got, want := tf.Absolute("en", then), "Feb 29, 2024 at 3:04 PM UTC"; got != want {
+		t.Errorf("Absolute(en) = %q, want %q", got, want)
+	}
+	if got, want := tf.Absolute("de", then), "29.02.2024 um 15:04 UTC"; got != want {
+		t.Errorf("Absolute(de) = %q, want %q", got, want)
+	}
+	if got, want := tf.Date("en", then), "February 29, 2024"; got != want {
+		t.Errorf("Date(en) = %q, want %q", got, want)
+	}
+	if got, want := tf.Date("de", then), "29.02.2024"; got != want {
+		t.Errorf("Date(de) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Video source kinds, in the order we'd normally prefer them.
+const (
+	VideoSourceDASH = "dash"
+	VideoSourceHLS  = "hls"
+	VideoSourceMP4  = "mp4"
+)
+
+// redditVideoHeights are the progressive MP4 renditions v.redd.it produces.
+// Each is available as "DASH_[height].mp4" when the source is large enough.
+var redditVideoHeights = []int{1080, 720, 480, 360, 240}
+
+// findRedditVideo extracts the metadata for a video hosted on v.redd.it. It
+// returns nil for any other kind of post.
+//
+// On feed pages, the player is part of the expando's cached HTML:
+//
+//	<div class="reddit-video-player-root"
+//	     data-mpd-url="https://v.redd.it/[ID]/DASHPlaylist.mpd?a=..."
+//	     data-hls-url="https://v.redd.it/[ID]/HLSPlaylist.m3u8?a=..."
+//	     data-seek-preview-url="https://v.redd.it/[ID]/DASH_96.mp4"
+//	     data-width="1280" data-height="720" data-duration="42">
+//	</div>
+//
+// If the player can't be found, the playlist URLs are derived from the post's
+// link instead.
+func findRedditVideo(n *html.Node, post *FeedPost) *RedditVideo {
+
+	u, err := url.Parse(post.PostLink)
+	if err != nil || !(hostIs(u, "v.redd.it") || hostIs(u, "v.reddit.com")) {
+		return nil
+	}
+
+	video, err := parseRedditVideoPlayer(n)
+	if err != nil {
+		// v.redd.it serves both playlists at well known paths
+		base := strings.TrimSuffix(post.PostLink, "/")
+		video = &RedditVideo{
+			DASHURL:  base + "/DASHPlaylist.mpd",
+			HLSURL:   base + "/HLSPlaylist.m3u8",
+			HasAudio: true,
+		}
+	}
+
+	video.Sources = chooseVideoSources(video)
+	return video
+}
+
+func parseRedditVideoPlayer(n *html.Node) (*RedditVideo, error) {
+
+	expando, err := findExpando(n)
+	if err != nil {
+		return nil, ErrVideoNotFound
+	}
+
+	player, err := BreadthFirstSearch(expando,
+		And(
+			IsTag(atom.Div),
+			HasClass("reddit-video-player-root"),
+		),
+		RecurseAlways,
+	)
+	if err != nil {
+		return nil, ErrVideoNotFound
+	}
+
+	// Reddit doesn't always say whether there's an audio track. Assume there
+	// is, since picking a silent source for a video with sound is worse than
+	// the reverse.
+	video := &RedditVideo{
+		HasAudio: true,
+	}
+	for _, attr := range player.Attr {
+		switch attr.Key {
+		case "data-mpd-url":
+			video.DASHURL = attr.Val
+		case "data-hls-url":
+			video.HLSURL = attr.Val
+		case "data-fallback-url":
+			video.FallbackURL = attr.Val
+		case "data-width":
+			if w, err := strconv.Atoi(attr.Val); err == nil {
+				video.Width = w
+			}
+		case "data-height":
+			if h, err := strconv.Atoi(attr.Val); err == nil {
+				video.Height = h
+			}
+		case "data-duration":
+			if d, err := strconv.Atoi(attr.Val); err == nil {
+				video.Duration = d
+			}
+		case "data-has-audio":
+			if a, err := strconv.ParseBool(attr.Val); err == nil {
+				video.HasAudio = a
+			}
+		case "data-is-gif":
+			if g, err := strconv.ParseBool(attr.Val); err == nil && g {
+				video.HasAudio = false
+			}
+		}
+	}
+	if video.DASHURL == "" && video.HLSURL == "" && video.FallbackURL == "" {
+		return nil, ErrVideoNotFound
+	}
+
+	// Derive the progressive fallback from the playlist's base URL when
+	// Reddit didn't provide one.
+	if video.FallbackURL == "" {
+		video.FallbackURL = redditVideoFallbackURL(video)
+	}
+
+	return video, nil
+}
+
+// redditVideoFallbackURL guesses the URL of the largest progressive MP4 for
+// 'video'. Renditions are named after the shorter side of the video, so a
+// 1280x720 video has a "DASH_720.mp4". It returns an empty string if the
+// dimensions are unknown.
+func redditVideoFallbackURL(video *RedditVideo) string {
+
+	playlist := video.DASHURL
+	if playlist == "" {
+		playlist = video.HLSURL
+	}
+	u, err := url.Parse(playlist)
+	if err != nil || video.Width <= 0 || video.Height <= 0 {
+		return ""
+	}
+
+	shortSide := min(video.Width, video.Height)
+	for _, h := range redditVideoHeights {
+		if h <= shortSide {
+			u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1] + "DASH_" + strconv.Itoa(h) + ".mp4"
+			u.RawQuery = ""
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// chooseVideoSources orders the available sources from best to worst.
+// Adaptive streams (DASH, then HLS) are preferred since they carry audio and
+// adapt to the connection. The progressive MP4 never has audio on v.redd.it,
+// so it's only preferred for silent videos, where it plays everywhere without
+// any JavaScript.
+func chooseVideoSources(video *RedditVideo) []VideoSource {
+
+	dash := VideoSource{Kind: VideoSourceDASH, URL: video.DASHURL, MIMEType: "application/dash+xml"}
+	hls := VideoSource{Kind: VideoSourceHLS, URL: video.HLSURL, MIMEType: "application/vnd.apple.mpegurl"}
+	mp4 := VideoSource{Kind: VideoSourceMP4, URL: video.FallbackURL, MIMEType: "video/mp4"}
+
+	order := []VideoSource{dash, hls, mp4}
+	if !video.HasAudio {
+		order = []VideoSource{mp4, hls, dash}
+	}
+
+	var sources []VideoSource
+	for _, s := range order {
+		if s.URL != "" {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}